@@ -1,56 +1,246 @@
+// Package cache keeps recent quotes in Redis so they can be shared between
+// the services. Each service opens one Cache at startup and uses it for its
+// whole life; the Redis Client keeps a pool of connections. A Memory cache
+// stands in when Redis is unavailable and in tests.
 package cache
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"os"
 	"strconv"
 	"time"
 
 	"github.com/go-redis/redis"
 )
 
+// A quote may be traded on for this long after it was quoted
 const MAX_QUOTE_VALIDITY_SECS = 60
 
-func connectToRedisCache() *redis.Client {
-	client := redis.NewClient(&redis.Options{
-		Addr:     "rediscache:6379",
-		Password: "", // no password set
-		DB:       0,  // use default DB
+const DEFAULT_ADDR = "rediscache:6379"
+
+var ErrMiss = errors.New("cache: miss")
+
+type Cache interface {
+	// Returns the cached quote for sym, or ErrMiss
+	GetQuote(ctx context.Context, sym string) (Quote, error)
+	// Caches q until it is MAX_QUOTE_VALIDITY_SECS old
+	SetQuote(ctx context.Context, q Quote) error
+	// Takes the lease lock called name, waiting until it is free or ctx is done
+	Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error)
+	Close() error
+}
+
+type Options struct {
+	Addr     string
+	Password string
+	DB       int
+
+	// Connections kept open to Redis; the redis default of ten per CPU if zero
+	PoolSize int
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Quotes kept in process in front of Redis, and for how long; off if
+	// LocalSize is zero
+	LocalSize int
+	LocalTTL  time.Duration
+
+	// How often Open retries Redis while it is down; RECONNECT_INTERVAL if zero
+	ReconnectInterval time.Duration
+}
+
+// Reads the options from REDIS_ADDR, REDIS_PASSWORD, REDIS_DB,
+// REDIS_POOL_SIZE, CACHE_LOCAL_SIZE and CACHE_LOCAL_TTL. Unset variables keep
+// their defaults.
+func OptionsFromEnv() (Options, error) {
+	opts := Options{Addr: DEFAULT_ADDR, DialTimeout: 5 * time.Second, ReadTimeout: 3 * time.Second, WriteTimeout: 3 * time.Second, LocalTTL: time.Second}
+
+	if addr, found := os.LookupEnv("REDIS_ADDR"); found {
+		opts.Addr = addr
+	}
+	opts.Password = os.Getenv("REDIS_PASSWORD")
+
+	if db, found := os.LookupEnv("REDIS_DB"); found {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return opts, fmt.Errorf("bad REDIS_DB %q", db)
+		}
+		opts.DB = n
+	}
+	if poolSize, found := os.LookupEnv("REDIS_POOL_SIZE"); found {
+		n, err := strconv.Atoi(poolSize)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("bad REDIS_POOL_SIZE %q", poolSize)
+		}
+		opts.PoolSize = n
+	}
+	if localSize, found := os.LookupEnv("CACHE_LOCAL_SIZE"); found {
+		n, err := strconv.Atoi(localSize)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("bad CACHE_LOCAL_SIZE %q", localSize)
+		}
+		opts.LocalSize = n
+	}
+	if localTTL, found := os.LookupEnv("CACHE_LOCAL_TTL"); found {
+		d, err := time.ParseDuration(localTTL)
+		if err != nil || d <= 0 {
+			return opts, fmt.Errorf("bad CACHE_LOCAL_TTL %q", localTTL)
+		}
+		opts.LocalTTL = d
+	}
+
+	return opts, nil
+}
+
+type Client struct {
+	rdb *redis.Client
+
+	// Tells this client's invalidations apart from everyone else's
+	id string
+
+	// Set when the local tier is on
+	local       *localTier
+	invalidates *redis.PubSub
+}
+
+// Connects to Redis, failing if it can't be reached
+func New(ctx context.Context, opts Options) (*Client, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:         opts.Addr,
+		Password:     opts.Password,
+		DB:           opts.DB,
+		PoolSize:     opts.PoolSize,
+		DialTimeout:  opts.DialTimeout,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
 	})
 
-	_, err := client.Ping().Result()
+	if err := rdb.WithContext(ctx).Ping().Err(); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+
+	idBuf := make([]byte, 8)
+	if _, err := rand.Read(idBuf); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+
+	c := &Client{rdb: rdb, id: hex.EncodeToString(idBuf)}
+	if opts.LocalSize > 0 {
+		if err := c.startLocalTier(opts.LocalSize, opts.LocalTTL); err != nil {
+			rdb.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// New with the options from the environment
+func NewFromEnv(ctx context.Context) (*Client, error) {
+	opts, err := OptionsFromEnv()
 	if err != nil {
-		fmt.Println("*************************")
-		log.Fatalln(err)
-		fmt.Println("*************************")
+		return nil, err
+	}
+	return New(ctx, opts)
+}
+
+func (c *Client) ping(ctx context.Context) error {
+	return c.rdb.WithContext(ctx).Ping().Err()
+}
+
+// Closes every pooled connection
+func (c *Client) Close() error {
+	if c.invalidates != nil {
+		c.invalidates.Close()
 	}
+	return c.rdb.Close()
+}
 
-	return client
+// A quote as the quote server gave it
+type Quote struct {
+	Price     float64 `json:"price"`
+	Sym       string  `json:"sym"`
+	Timestamp int64   `json:"timestamp"` // quote server time, unix ms
+	Cryptokey string  `json:"cryptokey"`
+	User      string  `json:"user"` // user the quote was fetched for
 }
 
-func SetKeyWithExpirationInSecs(key string, pricestck float64, expSecs uint) error {
-	var val string
-	secondsDelta := time.Duration(expSecs) * time.Second
-	val = strconv.FormatFloat(pricestck, 'f', -1, 64)
+func (q Quote) QuotedAt() time.Time {
+	return time.UnixMilli(q.Timestamp)
+}
 
-	err := connectToRedisCache().Set(key, val, secondsDelta).Err()
+func (q Quote) ExpiresAt() time.Time {
+	return q.QuotedAt().Add(MAX_QUOTE_VALIDITY_SECS * time.Second)
+}
 
+func (q Quote) Age(now time.Time) time.Duration {
+	return now.Sub(q.QuotedAt())
+}
+
+func quoteKey(sym string) string {
+	return "quote:" + sym
+}
+
+// Caches q as JSON until it is MAX_QUOTE_VALIDITY_SECS old. Quotes already
+// older than that are not cached.
+func (c *Client) SetQuote(ctx context.Context, q Quote) error {
+	exp := time.Until(q.ExpiresAt())
+	if exp <= 0 {
+		return nil
+	}
+
+	val, err := json.Marshal(q)
 	if err != nil {
-		return errors.New("Could not set key " + key + "with expiration")
+		return err
 	}
 
-	return nil
+	// Kept locally even if Redis can't be reached, so this process at least
+	// still has it
+	if c.local != nil {
+		c.local.set(q, time.Now())
+	}
+
+	if err := c.rdb.WithContext(ctx).Set(quoteKey(q.Sym), val, exp).Err(); err != nil {
+		return errors.New("Could not set quote for " + q.Sym + ": " + err.Error())
+	}
+	return c.publishInvalidate(ctx, q.Sym)
 }
 
-func GetKeyWithStringVal(key string) (string, error) {
-	val, err := connectToRedisCache().Get(key).Result()
-	return val, err
+func (c *Client) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	return acquire(ctx, redisLocks{rdb: c.rdb}, name, ttl)
 }
 
-func writeQuoteToCache(symbol string, quote float64) {
-	err := SetKeyWithExpirationInSecs(symbol, quote, 0)
+// Returns the cached quote for sym, or ErrMiss
+func (c *Client) GetQuote(ctx context.Context, sym string) (Quote, error) {
+	if c.local != nil {
+		if q, found := c.local.get(sym, time.Now()); found {
+			return q, nil
+		}
+	}
+
+	val, err := c.rdb.WithContext(ctx).Get(quoteKey(sym)).Bytes()
+	if err == redis.Nil {
+		return Quote{}, ErrMiss
+	}
 	if err != nil {
-		fmt.Println("Error caching quote. Symbol: ", symbol, " Quote: ", quote, "error: ", err)
+		return Quote{}, err
+	}
+
+	var q Quote
+	if err := json.Unmarshal(val, &q); err != nil {
+		return Quote{}, err
+	}
+
+	if c.local != nil {
+		c.local.set(q, time.Now())
 	}
+	return q, nil
 }
@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// How often a degraded cache tries to reach Redis again
+const RECONNECT_INTERVAL = 5 * time.Second
+
+// How long a failed request waits on Redis answering a ping before the cache
+// degrades
+const PING_TIMEOUT = time.Second
+
+// Opens the cache for a service. Whenever Redis can't be reached, at startup or
+// later, the service runs on a Memory cache when Options.LocalSize is set and
+// without a cache otherwise, while Redis is retried in the background and
+// switched back to once it answers. Until then locks only hold within the
+// process. The cache is metered.
+func Open(ctx context.Context, opts Options) *Metered {
+	if opts.ReconnectInterval <= 0 {
+		opts.ReconnectInterval = RECONNECT_INTERVAL
+	}
+	d := &degraded{opts: opts, done: make(chan struct{})}
+
+	c, err := New(ctx, opts)
+	if err != nil {
+		log.Printf("cache: redis unavailable, running with %s: %s\n", d.fallbackMode(), err)
+		d.current = d.newFallback()
+		go d.reconnect()
+	} else {
+		d.current, d.redis = c, c
+	}
+	return NewMetered(d)
+}
+
+// Open with the options from the environment. Fails only if they are invalid.
+func OpenFromEnv(ctx context.Context) (*Metered, error) {
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return Open(ctx, opts), nil
+}
+
+// Serves from Redis while it answers and from the fallback while it doesn't
+type degraded struct {
+	opts Options
+
+	mu      sync.Mutex
+	current Cache
+	redis   *Client // current while Redis is in use, nil while degraded
+	closed  bool
+	done    chan struct{}
+}
+
+func (d *degraded) fallbackMode() string {
+	if d.opts.LocalSize > 0 {
+		return "local cache"
+	}
+	return "no cache"
+}
+
+func (d *degraded) newFallback() Cache {
+	if d.opts.LocalSize > 0 {
+		return NewMemory(d.opts.LocalSize)
+	}
+	return noCache{locks: newMemoryLocks()}
+}
+
+// Switches from the Redis client c to a fallback, unless that has already
+// happened, and starts trying to reconnect
+func (d *degraded) degrade(c *Client, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed || d.redis != c {
+		return
+	}
+	log.Printf("cache: redis unavailable, running with %s: %s\n", d.fallbackMode(), err)
+	d.current = d.newFallback()
+	d.redis = nil
+	go c.Close()
+	go d.reconnect()
+}
+
+func (d *degraded) reconnect() {
+	ticker := time.NewTicker(d.opts.ReconnectInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), d.opts.DialTimeout+time.Second)
+		c, err := New(ctx, d.opts)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		d.mu.Lock()
+		if d.closed {
+			d.mu.Unlock()
+			c.Close()
+			return
+		}
+		fallback := d.current
+		d.current, d.redis = c, c
+		d.mu.Unlock()
+
+		fallback.Close()
+		log.Println("cache: redis reachable again")
+		return
+	}
+}
+
+func (d *degraded) cache() (Cache, *Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.current, d.redis
+}
+
+// Whether err from the Redis client c means Redis is down, in which case the
+// cache has degraded and the request should be made again. Misses, a caller
+// giving up and errors Redis itself replied with don't count; Redis is pinged
+// to tell the last apart from an outage.
+func (d *degraded) failed(c *Client, err error) bool {
+	if c == nil || err == nil || err == ErrMiss || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), PING_TIMEOUT)
+	defer cancel()
+	if c.ping(ctx) == nil {
+		return false
+	}
+
+	d.degrade(c, err)
+	return true
+}
+
+func (d *degraded) GetQuote(ctx context.Context, sym string) (Quote, error) {
+	current, redis := d.cache()
+	q, err := current.GetQuote(ctx, sym)
+	if d.failed(redis, err) {
+		current, _ = d.cache()
+		return current.GetQuote(ctx, sym)
+	}
+	return q, err
+}
+
+func (d *degraded) SetQuote(ctx context.Context, q Quote) error {
+	current, redis := d.cache()
+	err := current.SetQuote(ctx, q)
+	if d.failed(redis, err) {
+		current, _ = d.cache()
+		return current.SetQuote(ctx, q)
+	}
+	return err
+}
+
+func (d *degraded) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	current, redis := d.cache()
+	lease, err := current.Acquire(ctx, name, ttl)
+	if d.failed(redis, err) {
+		current, _ = d.cache()
+		return current.Acquire(ctx, name, ttl)
+	}
+	return lease, err
+}
+
+func (d *degraded) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	close(d.done)
+	return d.current.Close()
+}
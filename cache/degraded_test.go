@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testOptions(addr string) Options {
+	return Options{
+		Addr:              addr,
+		DialTimeout:       200 * time.Millisecond,
+		ReadTimeout:       200 * time.Millisecond,
+		WriteTimeout:      200 * time.Millisecond,
+		LocalTTL:          time.Second,
+		ReconnectInterval: 20 * time.Millisecond,
+	}
+}
+
+// An address nothing listens on
+func deadAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func usingRedis(d *degraded) bool {
+	_, redis := d.cache()
+	return redis != nil
+}
+
+func TestOpenWithoutRedis(t *testing.T) {
+	ctx := context.Background()
+	q := quoteAt("ABC", time.Now())
+
+	tests := []struct {
+		localSize int
+		wantHit   bool
+	}{
+		{0, false},
+		{8, true},
+	}
+	for _, tt := range tests {
+		opts := testOptions(deadAddr(t))
+		opts.LocalSize = tt.localSize
+		m := Open(ctx, opts)
+
+		if err := m.SetQuote(ctx, q); err != nil {
+			t.Fatalf("local size %d: %s", tt.localSize, err)
+		}
+		_, err := m.GetQuote(ctx, "ABC")
+		if tt.wantHit && err != nil {
+			t.Errorf("local size %d: got %v, want a hit", tt.localSize, err)
+		}
+		if !tt.wantHit && err != ErrMiss {
+			t.Errorf("local size %d: got %v, want ErrMiss", tt.localSize, err)
+		}
+
+		// Locks still work within the process
+		lease, err := m.Acquire(ctx, "alice", time.Second)
+		if err != nil {
+			t.Fatalf("local size %d: %s", tt.localSize, err)
+		}
+		if err := lease.Release(ctx); err != nil {
+			t.Errorf("local size %d: %s", tt.localSize, err)
+		}
+		m.Close()
+	}
+}
+
+func TestDegradesWhileRedisIsDown(t *testing.T) {
+	ctx := context.Background()
+	redis := startFakeRedis(t)
+
+	m := Open(ctx, testOptions(redis.addr))
+	defer m.Close()
+	d := m.Cache.(*degraded)
+	if !usingRedis(d) {
+		t.Fatal("not using redis although it is up")
+	}
+
+	q := quoteAt("ABC", time.Now())
+	if err := m.SetQuote(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+
+	redis.stop()
+
+	// The outage is absorbed: no error, just no cache
+	if _, err := m.GetQuote(ctx, "ABC"); err != ErrMiss {
+		t.Fatalf("redis down: got %v, want ErrMiss", err)
+	}
+	if usingRedis(d) {
+		t.Fatal("still using redis after it went down")
+	}
+	if err := m.SetQuote(ctx, q); err != nil {
+		t.Fatalf("redis down: %s", err)
+	}
+
+	redis.restart(t)
+	waitFor(t, "redis to be used again", func() bool { return usingRedis(d) })
+
+	got, err := m.GetQuote(ctx, "ABC")
+	if err != nil {
+		t.Fatalf("redis back: %s", err)
+	}
+	if got != q {
+		t.Fatalf("got %+v, want %+v", got, q)
+	}
+}
+
+func TestRedisErrorRepliesDoNotDegrade(t *testing.T) {
+	ctx := context.Background()
+	redis := startFakeRedis(t)
+
+	m := Open(ctx, testOptions(redis.addr))
+	defer m.Close()
+	d := m.Cache.(*degraded)
+
+	// Not JSON, so the get fails while Redis answers
+	redis.do([]string{"SET", quoteKey("ABC"), "garbage"})
+	if _, err := m.GetQuote(ctx, "ABC"); err == nil || err == ErrMiss {
+		t.Fatalf("got %v, want a decode error", err)
+	}
+	if !usingRedis(d) {
+		t.Fatal("degraded although redis answers")
+	}
+}
+
+type closeCounter struct {
+	Cache
+	closes int32
+}
+
+func (c *closeCounter) Close() error {
+	atomic.AddInt32(&c.closes, 1)
+	return c.Cache.Close()
+}
+
+func TestReconnectClosesTheFallback(t *testing.T) {
+	redis := startFakeRedis(t)
+
+	fallback := &closeCounter{Cache: noCache{locks: newMemoryLocks()}}
+	d := &degraded{opts: testOptions(redis.addr), current: fallback, done: make(chan struct{})}
+	defer d.Close()
+
+	go d.reconnect()
+	waitFor(t, "redis to be used again", func() bool { return usingRedis(d) })
+
+	if n := atomic.LoadInt32(&fallback.closes); n != 1 {
+		t.Fatalf("fallback closed %d times, want once", n)
+	}
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// An in-process tier in front of Redis holding the most recently used quotes.
+// Entries live for at most Options.LocalTTL, which should be well under the
+// validity window, and never past the quote's own expiry. When any client
+// writes a quote it publishes "<client id> <sym>" on INVALIDATE_CHANNEL and
+// every other client drops its local copy, so replicas don't keep serving a
+// price that has been replaced. Invalidations sent while a client is
+// reconnecting to Redis are lost, which LocalTTL bounds.
+
+const INVALIDATE_CHANNEL = "quote-invalidate"
+
+type localEntry struct {
+	quote     Quote
+	expiresAt time.Time
+}
+
+type localTier struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // most recently used at the front
+	entries map[string]*list.Element
+}
+
+func newLocalTier(size int, ttl time.Duration) *localTier {
+	return &localTier{size: size, ttl: ttl, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (l *localTier) get(sym string, now time.Time) (Quote, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, found := l.entries[sym]
+	if !found {
+		return Quote{}, false
+	}
+	e := el.Value.(*localEntry)
+	if !now.Before(e.expiresAt) {
+		l.order.Remove(el)
+		delete(l.entries, sym)
+		return Quote{}, false
+	}
+
+	l.order.MoveToFront(el)
+	return e.quote, true
+}
+
+func (l *localTier) set(q Quote, now time.Time) {
+	expiresAt := now.Add(l.ttl)
+	if q.ExpiresAt().Before(expiresAt) {
+		expiresAt = q.ExpiresAt()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, found := l.entries[q.Sym]; found {
+		el.Value = &localEntry{quote: q, expiresAt: expiresAt}
+		l.order.MoveToFront(el)
+		return
+	}
+
+	l.entries[q.Sym] = l.order.PushFront(&localEntry{quote: q, expiresAt: expiresAt})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*localEntry).quote.Sym)
+	}
+}
+
+func (l *localTier) remove(sym string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, found := l.entries[sym]; found {
+		l.order.Remove(el)
+		delete(l.entries, sym)
+	}
+}
+
+// Subscribes to invalidations and starts dropping the quotes they name
+func (c *Client) startLocalTier(size int, ttl time.Duration) error {
+	ps := c.rdb.Subscribe(INVALIDATE_CHANNEL)
+	if _, err := ps.Receive(); err != nil {
+		ps.Close()
+		return err
+	}
+
+	c.local = newLocalTier(size, ttl)
+	c.invalidates = ps
+	go c.listenInvalidations(ps.Channel())
+	return nil
+}
+
+func (c *Client) listenInvalidations(messages <-chan *redis.Message) {
+	for msg := range messages {
+		id, sym, found := strings.Cut(msg.Payload, " ")
+		if !found {
+			log.Printf("cache: bad invalidation %q\n", msg.Payload)
+			continue
+		}
+		// Our own writes are already in the local tier
+		if id == c.id {
+			continue
+		}
+		c.local.remove(sym)
+	}
+}
+
+// Tells every other client that the quote for sym has changed. Clients without
+// a local tier publish too, as they may share Redis with ones that have one.
+func (c *Client) publishInvalidate(ctx context.Context, sym string) error {
+	return c.rdb.WithContext(ctx).Publish(INVALIDATE_CHANNEL, c.id+" "+sym).Err()
+}
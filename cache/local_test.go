@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func quoteAt(sym string, at time.Time) Quote {
+	return Quote{Price: 12.34, Sym: sym, Timestamp: at.UnixMilli(), Cryptokey: "key", User: "alice"}
+}
+
+func TestLocalTierTTL(t *testing.T) {
+	now := time.Now()
+	tier := newLocalTier(8, time.Second)
+	tier.set(quoteAt("ABC", now), now)
+
+	if _, found := tier.get("ABC", now.Add(999*time.Millisecond)); !found {
+		t.Fatal("quote gone before its TTL")
+	}
+	if _, found := tier.get("ABC", now.Add(time.Second)); found {
+		t.Fatal("quote kept past its TTL")
+	}
+}
+
+func TestLocalTierNeverOutlivesTheQuote(t *testing.T) {
+	now := time.Now()
+	tier := newLocalTier(8, time.Hour)
+
+	// Quoted long enough ago that it has a second of validity left
+	q := quoteAt("ABC", now.Add(-(MAX_QUOTE_VALIDITY_SECS-1)*time.Second))
+	tier.set(q, now)
+
+	if _, found := tier.get("ABC", now.Add(500*time.Millisecond)); !found {
+		t.Fatal("quote gone while still valid")
+	}
+	if _, found := tier.get("ABC", q.ExpiresAt()); found {
+		t.Fatal("quote kept past its expiry")
+	}
+}
+
+func TestLocalTierEvictsLeastRecentlyUsed(t *testing.T) {
+	now := time.Now()
+	tier := newLocalTier(2, time.Minute)
+
+	tier.set(quoteAt("A", now), now)
+	tier.set(quoteAt("B", now), now)
+	// Using A leaves B the least recently used
+	if _, found := tier.get("A", now); !found {
+		t.Fatal("A missing")
+	}
+	tier.set(quoteAt("C", now), now)
+
+	if _, found := tier.get("B", now); found {
+		t.Error("B kept over the size limit")
+	}
+	for _, sym := range []string{"A", "C"} {
+		if _, found := tier.get(sym, now); !found {
+			t.Errorf("%s evicted", sym)
+		}
+	}
+}
+
+func TestLocalTierReplacesAndRemoves(t *testing.T) {
+	now := time.Now()
+	tier := newLocalTier(2, time.Minute)
+
+	tier.set(quoteAt("A", now), now)
+	newer := quoteAt("A", now.Add(time.Second))
+	newer.Price = 20
+	tier.set(newer, now)
+
+	if got, _ := tier.get("A", now); got != newer {
+		t.Fatalf("got %+v, want %+v", got, newer)
+	}
+	if tier.order.Len() != 1 {
+		t.Fatalf("%d entries after replacing one", tier.order.Len())
+	}
+
+	tier.remove("A")
+	if _, found := tier.get("A", now); found {
+		t.Fatal("A kept after removal")
+	}
+}
+
+func TestPublishEvictsOtherLocalTiers(t *testing.T) {
+	ctx := context.Background()
+	redis := startFakeRedis(t)
+	opts := testOptions(redis.addr)
+	opts.LocalSize = 8
+	opts.LocalTTL = time.Minute
+
+	writer, err := New(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	reader, err := New(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	old := quoteAt("ABC", time.Now())
+	if err := writer.SetQuote(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.GetQuote(ctx, "ABC"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := reader.local.get("ABC", time.Now()); !found {
+		t.Fatal("reader didn't keep the quote locally")
+	}
+
+	newer := quoteAt("ABC", time.Now())
+	newer.Price = 20
+	if err := writer.SetQuote(ctx, newer); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the reader's copy to be evicted", func() bool {
+		_, found := reader.local.get("ABC", time.Now())
+		return !found
+	})
+
+	if got, err := reader.GetQuote(ctx, "ABC"); err != nil || got != newer {
+		t.Fatalf("got %+v, %v, want %+v", got, err, newer)
+	}
+	// The writer's own copy is the quote it wrote, not evicted by its publish
+	if got, found := writer.local.get("ABC", time.Now()); !found || got != newer {
+		t.Errorf("writer has %+v, %v, want %+v", got, found, newer)
+	}
+}
@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Lease locks. A lease is held under a random token and expires on its own
+// after its TTL, so a crashed holder can't keep it forever. While held it is
+// renewed every third of its TTL; if renewal fails past the expiry the lease
+// is lost, which the holder learns from Lost. Release and renewal only act if
+// the token still matches, so a holder whose lease expired can't release or
+// extend the lease someone else has since taken.
+
+var ErrLockLost = errors.New("cache: lock lost")
+
+// Backoff between attempts to take a held lock
+const (
+	MIN_LOCK_RETRY = 10 * time.Millisecond
+	MAX_LOCK_RETRY = 200 * time.Millisecond
+)
+
+type lockStore interface {
+	tryAcquire(ctx context.Context, key string, token string, ttl time.Duration) (bool, error)
+	renew(ctx context.Context, key string, token string, ttl time.Duration) (bool, error)
+	release(ctx context.Context, key string, token string) (bool, error)
+}
+
+type Lease struct {
+	store lockStore
+	key   string
+	token string
+	ttl   time.Duration
+
+	lost      chan struct{}
+	stop      chan struct{}
+	releasing sync.Once
+}
+
+func lockKey(name string) string {
+	return "lock:" + name
+}
+
+// Takes the lock called name, waiting until it is free or ctx is done
+func acquire(ctx context.Context, store lockStore, name string, ttl time.Duration) (*Lease, error) {
+	tokenBuf := make([]byte, 16)
+	if _, err := rand.Read(tokenBuf); err != nil {
+		return nil, err
+	}
+	l := &Lease{store: store, key: lockKey(name), token: hex.EncodeToString(tokenBuf), ttl: ttl, lost: make(chan struct{}), stop: make(chan struct{})}
+
+	backoff := MIN_LOCK_RETRY
+	for {
+		ok, err := store.tryAcquire(ctx, l.key, l.token, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			go l.renewLoop()
+			return l, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > MAX_LOCK_RETRY {
+			backoff = MAX_LOCK_RETRY
+		}
+	}
+}
+
+// Closed once the lease has expired or been taken over without being released
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *Lease) renewLoop() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	expiresAt := time.Now().Add(l.ttl)
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+		renewedAt := time.Now()
+		ok, err := l.store.renew(ctx, l.key, l.token, l.ttl)
+		cancel()
+
+		switch {
+		case err == nil && ok:
+			expiresAt = renewedAt.Add(l.ttl)
+			continue
+		case err == nil:
+			log.Printf("cache: lease on %s taken over\n", l.key)
+		case time.Now().Before(expiresAt):
+			// Try again next tick while the lease may still be ours
+			continue
+		default:
+			log.Printf("cache: lease on %s expired: %s\n", l.key, err)
+		}
+		close(l.lost)
+		return
+	}
+}
+
+// Stops renewing and gives the lock up. Returns ErrLockLost if it had already
+// expired or been taken over.
+func (l *Lease) Release(ctx context.Context) error {
+	err := ErrLockLost
+	l.releasing.Do(func() {
+		close(l.stop)
+
+		var ok bool
+		ok, err = l.store.release(ctx, l.key, l.token)
+		if err == nil && !ok {
+			err = ErrLockLost
+		}
+	})
+	return err
+}
+
+// Deletes or extends the key only while it still holds our token
+var (
+	releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+	renewScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+type redisLocks struct {
+	rdb *redis.Client
+}
+
+func (r redisLocks) tryAcquire(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	return r.rdb.WithContext(ctx).SetNX(key, token, ttl).Result()
+}
+
+func (r redisLocks) renew(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	n, err := renewScript.Run(r.rdb.WithContext(ctx), []string{key}, token, ttl.Milliseconds()).Int64()
+	return n == 1, err
+}
+
+func (r redisLocks) release(ctx context.Context, key string, token string) (bool, error) {
+	n, err := releaseScript.Run(r.rdb.WithContext(ctx), []string{key}, token).Int64()
+	return n == 1, err
+}
+
+// Locks shared only within this process, for running without Redis
+type memoryLocks struct {
+	mu    sync.Mutex
+	locks map[string]memoryLock
+}
+
+type memoryLock struct {
+	token     string
+	expiresAt time.Time
+}
+
+func newMemoryLocks() *memoryLocks {
+	return &memoryLocks{locks: map[string]memoryLock{}}
+}
+
+// Whether token holds an unexpired lock on key
+func (m *memoryLocks) held(key string, token string) bool {
+	l, found := m.locks[key]
+	return found && l.token == token && time.Now().Before(l.expiresAt)
+}
+
+func (m *memoryLocks) tryAcquire(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l, found := m.locks[key]; found && time.Now().Before(l.expiresAt) {
+		return false, nil
+	}
+	m.locks[key] = memoryLock{token: token, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *memoryLocks) renew(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.held(key, token) {
+		return false, nil
+	}
+	m.locks[key] = memoryLock{token: token, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *memoryLocks) release(ctx context.Context, key string, token string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.held(key, token) {
+		return false, nil
+	}
+	delete(m.locks, key)
+	return true, nil
+}
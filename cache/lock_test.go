@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func isHeld(locks *memoryLocks, key string, token string) bool {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	return locks.held(key, token)
+}
+
+func TestLockIsMutuallyExclusive(t *testing.T) {
+	locks := newMemoryLocks()
+	ctx := context.Background()
+
+	var holders, overlaps int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			lease, err := acquire(ctx, locks, "alice", time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if atomic.AddInt32(&holders, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&holders, -1)
+
+			if err := lease.Release(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if overlaps != 0 {
+		t.Fatalf("lock held by two holders %d times", overlaps)
+	}
+}
+
+func TestAcquireWaitsForContext(t *testing.T) {
+	locks := newMemoryLocks()
+	lease, err := acquire(context.Background(), locks, "alice", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lease.Release(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := acquire(ctx, locks, "alice", time.Second); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+
+	// Other names are free
+	other, err := acquire(context.Background(), locks, "bob", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Release(context.Background())
+}
+
+func TestReleaseChecksTheToken(t *testing.T) {
+	locks := newMemoryLocks()
+	ctx := context.Background()
+
+	lease, err := acquire(ctx, locks, "alice", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else's lease on the same key can't release it
+	impostor := &Lease{store: locks, key: lease.key, token: "someone else", ttl: time.Second, lost: make(chan struct{}), stop: make(chan struct{})}
+	if err := impostor.Release(ctx); err != ErrLockLost {
+		t.Fatalf("impostor release: got %v, want ErrLockLost", err)
+	}
+	if !isHeld(locks, lease.key, lease.token) {
+		t.Fatal("lock gone after an impostor released it")
+	}
+
+	if err := lease.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// A second release has nothing left to give up
+	if err := lease.Release(ctx); err != ErrLockLost {
+		t.Fatalf("second release: got %v, want ErrLockLost", err)
+	}
+}
+
+func TestRenewKeepsTheLease(t *testing.T) {
+	locks := newMemoryLocks()
+	ctx := context.Background()
+	ttl := 60 * time.Millisecond
+
+	lease, err := acquire(ctx, locks, "alice", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(4 * ttl)
+	select {
+	case <-lease.Lost():
+		t.Fatal("lease lost although it was renewed")
+	default:
+	}
+	if !isHeld(locks, lease.key, lease.token) {
+		t.Fatal("lock expired although it was renewed")
+	}
+
+	if err := lease.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpiredLeaseIsLost(t *testing.T) {
+	locks := newMemoryLocks()
+	ctx := context.Background()
+	ttl := 60 * time.Millisecond
+
+	lease, err := acquire(ctx, locks, "alice", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expire it behind the holder's back, as if renewal had stalled, and let
+	// another holder in
+	locks.mu.Lock()
+	locks.locks[lease.key] = memoryLock{token: lease.token, expiresAt: time.Now()}
+	locks.mu.Unlock()
+
+	next, err := acquire(ctx, locks, "alice", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Release(ctx)
+
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lease not lost after being taken over")
+	}
+	if err := lease.Release(ctx); err != ErrLockLost {
+		t.Fatalf("release after takeover: got %v, want ErrLockLost", err)
+	}
+	if !isHeld(locks, next.key, next.token) {
+		t.Fatal("the old holder released the new holder's lock")
+	}
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// A Cache held entirely in process, for running without Redis. It shares
+// nothing with other processes, locks included.
+type Memory struct {
+	tier  *localTier
+	locks *memoryLocks
+}
+
+// An in-process cache of up to size quotes, each kept for its whole validity
+// window
+func NewMemory(size int) *Memory {
+	return &Memory{tier: newLocalTier(size, MAX_QUOTE_VALIDITY_SECS*time.Second), locks: newMemoryLocks()}
+}
+
+func (m *Memory) GetQuote(ctx context.Context, sym string) (Quote, error) {
+	if q, found := m.tier.get(sym, time.Now()); found {
+		return q, nil
+	}
+	return Quote{}, ErrMiss
+}
+
+func (m *Memory) SetQuote(ctx context.Context, q Quote) error {
+	if time.Until(q.ExpiresAt()) > 0 {
+		m.tier.set(q, time.Now())
+	}
+	return nil
+}
+
+func (m *Memory) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	return acquire(ctx, m.locks, name, ttl)
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// A Cache that caches nothing. Its locks are in process.
+type noCache struct {
+	locks *memoryLocks
+}
+
+func (noCache) GetQuote(ctx context.Context, sym string) (Quote, error) {
+	return Quote{}, ErrMiss
+}
+
+func (noCache) SetQuote(ctx context.Context, q Quote) error {
+	return nil
+}
+
+func (n noCache) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	return acquire(ctx, n.locks, name, ttl)
+}
+
+func (noCache) Close() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryRoundTrip(t *testing.T) {
+	m := NewMemory(8)
+	ctx := context.Background()
+
+	if _, err := m.GetQuote(ctx, "ABC"); err != ErrMiss {
+		t.Fatalf("empty cache: got %v, want ErrMiss", err)
+	}
+
+	q := quoteAt("ABC", time.Now())
+	if err := m.SetQuote(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.GetQuote(ctx, "ABC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != q {
+		t.Fatalf("got %+v, want %+v", got, q)
+	}
+}
+
+func TestMemorySkipsExpiredQuotes(t *testing.T) {
+	m := NewMemory(8)
+	ctx := context.Background()
+
+	old := quoteAt("ABC", time.Now().Add(-(MAX_QUOTE_VALIDITY_SECS+1)*time.Second))
+	if err := m.SetQuote(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetQuote(ctx, "ABC"); err != ErrMiss {
+		t.Fatalf("expired quote: got %v, want ErrMiss", err)
+	}
+}
+
+func TestNoCacheNeverHits(t *testing.T) {
+	n := noCache{locks: newMemoryLocks()}
+	ctx := context.Background()
+
+	if err := n.SetQuote(ctx, quoteAt("ABC", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.GetQuote(ctx, "ABC"); err != ErrMiss {
+		t.Fatalf("got %v, want ErrMiss", err)
+	}
+}
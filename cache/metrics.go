@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Counts what a Cache is asked and how it answers. Errors are failures of
+// the cache itself; a miss is not an error, and neither is giving up on a
+// lock someone else holds.
+
+type Latency struct {
+	Count uint64  `json:"count"`
+	AvgMs float64 `json:"avgMs"`
+	MaxMs float64 `json:"maxMs"`
+}
+
+type MetricsSnapshot struct {
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	Errors  uint64  `json:"errors"`
+	HitRate float64 `json:"hitRate"`
+	Get     Latency `json:"get"`
+	Set     Latency `json:"set"`
+	Lock    Latency `json:"lock"`
+}
+
+type latency struct {
+	count uint64
+	total time.Duration
+	max   time.Duration
+}
+
+func (l *latency) add(d time.Duration) {
+	l.count++
+	l.total += d
+	if d > l.max {
+		l.max = d
+	}
+}
+
+func (l latency) snapshot() Latency {
+	s := Latency{Count: l.count, MaxMs: float64(l.max.Microseconds()) / 1000}
+	if l.count > 0 {
+		s.AvgMs = float64(l.total.Microseconds()) / 1000 / float64(l.count)
+	}
+	return s
+}
+
+// A Cache that keeps metrics on the Cache it wraps
+type Metered struct {
+	Cache
+
+	mu                   sync.Mutex
+	hits, misses, errors uint64
+	get, set, lock       latency
+}
+
+func NewMetered(c Cache) *Metered {
+	return &Metered{Cache: c}
+}
+
+func (m *Metered) GetQuote(ctx context.Context, sym string) (Quote, error) {
+	start := time.Now()
+	q, err := m.Cache.GetQuote(ctx, sym)
+	took := time.Since(start)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.get.add(took)
+	switch {
+	case err == nil:
+		m.hits++
+	case err == ErrMiss:
+		m.misses++
+	default:
+		m.errors++
+	}
+	return q, err
+}
+
+func (m *Metered) SetQuote(ctx context.Context, q Quote) error {
+	start := time.Now()
+	err := m.Cache.SetQuote(ctx, q)
+	took := time.Since(start)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set.add(took)
+	if err != nil {
+		m.errors++
+	}
+	return err
+}
+
+func (m *Metered) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	start := time.Now()
+	lease, err := m.Cache.Acquire(ctx, name, ttl)
+	took := time.Since(start)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lock.add(took)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		m.errors++
+	}
+	return lease, err
+}
+
+func (m *Metered) Metrics() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := MetricsSnapshot{Hits: m.hits, Misses: m.misses, Errors: m.errors, Get: m.get.snapshot(), Set: m.set.snapshot(), Lock: m.lock.snapshot()}
+	if m.hits+m.misses > 0 {
+		s.HitRate = float64(m.hits) / float64(m.hits+m.misses)
+	}
+	return s
+}
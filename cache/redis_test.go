@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Just enough of a Redis server for the cache: PING, GET, SET with EX, PX and
+// NX, DEL, SUBSCRIBE and PUBLISH. Its data outlives stop, so it can be
+// restarted on the same address as if Redis had come back.
+type fakeRedis struct {
+	addr string
+
+	mu          sync.Mutex
+	ln          net.Listener
+	conns       map[net.Conn]bool
+	subscribers map[*fakeConn]bool
+	data        map[string]string
+	expires     map[string]time.Time
+}
+
+// A client connection, which PUBLISH writes to as well as the connection's
+// own replies once it has subscribed
+type fakeConn struct {
+	nc       net.Conn
+	mu       sync.Mutex
+	channels map[string]bool // guarded by fakeRedis.mu
+}
+
+func (fc *fakeConn) write(s string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	_, err := io.WriteString(fc.nc, s)
+	return err
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	f := &fakeRedis{
+		conns:       map[net.Conn]bool{},
+		subscribers: map[*fakeConn]bool{},
+		data:        map[string]string{},
+		expires:     map[string]time.Time{},
+	}
+	f.listen(t, "127.0.0.1:0")
+	t.Cleanup(f.stop)
+	return f
+}
+
+func (f *fakeRedis) listen(t *testing.T, addr string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	f.ln = ln
+	f.addr = ln.Addr().String()
+	f.mu.Unlock()
+
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns[nc] = true
+			f.mu.Unlock()
+			go f.serve(nc)
+		}
+	}()
+}
+
+// Closes the listener and every connection, like Redis going away
+func (f *fakeRedis) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ln != nil {
+		f.ln.Close()
+		f.ln = nil
+	}
+	for nc := range f.conns {
+		nc.Close()
+		delete(f.conns, nc)
+	}
+}
+
+func (f *fakeRedis) restart(t *testing.T) {
+	f.listen(t, f.addr)
+}
+
+func (f *fakeRedis) serve(nc net.Conn) {
+	defer nc.Close()
+
+	fc := &fakeConn{nc: nc, channels: map[string]bool{}}
+	defer func() {
+		f.mu.Lock()
+		delete(f.subscribers, fc)
+		f.mu.Unlock()
+	}()
+
+	reader := bufio.NewReader(nc)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		if err := fc.write(f.doOn(fc, args)); err != nil {
+			return
+		}
+	}
+}
+
+// Runs the commands that depend on the connection, and the rest through do
+func (f *fakeRedis) doOn(fc *fakeConn, args []string) string {
+	f.mu.Lock()
+	subscribed := len(fc.channels) > 0
+	f.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "SUBSCRIBE":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		reply := ""
+		for _, channel := range args[1:] {
+			fc.channels[channel] = true
+			reply += fmt.Sprintf("*3\r\n%s%s:%d\r\n", bulk("subscribe"), bulk(channel), len(fc.channels))
+		}
+		f.subscribers[fc] = true
+		return reply
+	case "PING":
+		// A subscribed connection answers in the shape of a message
+		if subscribed {
+			payload := ""
+			if len(args) > 1 {
+				payload = args[1]
+			}
+			return "*2\r\n" + bulk("pong") + bulk(payload)
+		}
+	}
+	return f.do(args)
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func (f *fakeRedis) do(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Drops the key if it has expired
+	live := func(key string) bool {
+		if exp, found := f.expires[key]; found && !time.Now().Before(exp) {
+			delete(f.data, key)
+			delete(f.expires, key)
+		}
+		_, found := f.data[key]
+		return found
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		if !live(args[1]) {
+			return "$-1\r\n"
+		}
+		return bulk(f.data[args[1]])
+	case "SET":
+		key, val := args[1], args[2]
+		var ttl time.Duration
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "EX", "PX":
+				n, _ := strconv.Atoi(args[i+1])
+				ttl = time.Duration(n) * time.Millisecond
+				if strings.ToUpper(args[i]) == "EX" {
+					ttl = time.Duration(n) * time.Second
+				}
+				i++
+			case "NX":
+				nx = true
+			}
+		}
+		if nx && live(key) {
+			return "$-1\r\n"
+		}
+		f.data[key] = val
+		delete(f.expires, key)
+		if ttl > 0 {
+			f.expires[key] = time.Now().Add(ttl)
+		}
+		return "+OK\r\n"
+	case "DEL":
+		n := 0
+		for _, key := range args[1:] {
+			if live(key) {
+				delete(f.data, key)
+				delete(f.expires, key)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "PUBLISH":
+		n := 0
+		for fc := range f.subscribers {
+			if fc.channels[args[1]] {
+				fc.write("*3\r\n" + bulk("message") + bulk(args[1]) + bulk(args[2]))
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sync"
+)
+
+// An in-flight quote server request that later callers for the same symbol
+// wait on instead of making their own
+type quoteCall struct {
+	wg  sync.WaitGroup
+	val quote_hit
+	err error
+}
+
+var quote_calls = map[string]*quoteCall{}
+var quote_calls_mu sync.Mutex
+
+// Fetches a quote for sym, sharing a request already in flight for the same
+// symbol. shared reports whether the quote came from another caller's request,
+// in which case that caller takes care of writing it to the cache.
+func coalesced_quote(sym string, username string) (q quote_hit, shared bool, err error) {
+	quote_calls_mu.Lock()
+	if call, found := quote_calls[sym]; found {
+		quote_calls_mu.Unlock()
+		call.wg.Wait()
+		return call.val, true, call.err
+	}
+
+	call := &quoteCall{}
+	call.wg.Add(1)
+	quote_calls[sym] = call
+	quote_calls_mu.Unlock()
+
+	call.val, call.err = quote_price(sym, username)
+
+	quote_calls_mu.Lock()
+	delete(quote_calls, sym)
+	quote_calls_mu.Unlock()
+	call.wg.Done()
+
+	return call.val, false, call.err
+}
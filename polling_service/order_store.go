@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// States an armed order goes through in the store. An order is claimed
+// (armed -> firing) before it is sent to the transaction server and deleted
+// once the transaction server has answered it. If sending fails the order goes
+// back to armed, and orders a crash left firing are re-armed at startup, so an
+// order is never lost; the price is tested again before it is re-sent. Every
+// send carries the order's OrderId, which the transaction server commits at
+// most once, so an order that did go through before the failure isn't fired
+// twice.
+const (
+	ORDER_ARMED  = "armed"
+	ORDER_FIRING = "firing"
+)
+
+var ErrOrderFiring = errors.New("order is firing")
+
+type storedOrder struct {
+	Key   string     `bson:"_id"`
+	Order LimitOrder `bson:"order"`
+	State string     `bson:"state"`
+}
+
+var orders_collection *mongo.Collection
+
+func connectDb(databaseUri string) (*mongo.Client, error) {
+	// adapted from https://github.com/mongodb/mongo-go-driver/blob/d957e67225a9ea82f1c7159020b4f9fd7c8d441a/README.md#usage
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return mongo.Connect(ctx, options.Client().ApplyURI(databaseUri))
+}
+
+func order_key(user string, orderType string, stock string) string {
+	return user + "/" + orderType + "/" + stock
+}
+
+// Saves (or re-arms) an order so it survives a restart. An order that is
+// being fired can't be replaced until it has been sent, as deleting it
+// afterwards would delete the replacement; ErrOrderFiring is returned instead.
+func store_order(o LimitOrder) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := order_key(o.User, o.Type, o.Stock)
+	doc := storedOrder{Key: key, Order: o, State: ORDER_ARMED}
+	// A firing document doesn't match, so the upsert collides with its _id
+	filter := bson.M{"_id": key, "state": bson.M{"$ne": ORDER_FIRING}}
+	_, err := orders_collection.ReplaceOne(ctx, filter, doc, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrOrderFiring
+	}
+	return err
+}
+
+// Atomically moves an armed order to the firing state and returns it as
+// stored, which is the order to fire: the user may have set the trigger again
+// since o was read. Reports false if the order was cancelled or claimed in the
+// meantime.
+func claim_order(o LimitOrder) (LimitOrder, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": order_key(o.User, o.Type, o.Stock), "state": ORDER_ARMED}
+	update := bson.M{"$set": bson.M{"state": ORDER_FIRING}}
+	var doc storedOrder
+	err := orders_collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return LimitOrder{}, false, nil
+	}
+	if err != nil {
+		return LimitOrder{}, false, err
+	}
+	return doc.Order, true, nil
+}
+
+// Removes an order that has been sent to the transaction server
+func delete_order(o LimitOrder) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": order_key(o.User, o.Type, o.Stock), "state": ORDER_FIRING}
+	_, err := orders_collection.DeleteOne(ctx, filter)
+	return err
+}
+
+// Moves a firing order back to armed so it is evaluated again
+func rearm_order(o LimitOrder) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": order_key(o.User, o.Type, o.Stock), "state": ORDER_FIRING}
+	_, err := orders_collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"state": ORDER_ARMED}})
+	return err
+}
+
+// Removes an order that has not fired yet. Reports whether one was removed.
+func cancel_stored_order(user string, orderType string, stock string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": order_key(user, orderType, stock), "state": ORDER_ARMED}
+	res, err := orders_collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+// Loads orders by state, used at startup to resume evaluation
+func load_orders(state string) ([]LimitOrder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := orders_collection.Find(ctx, bson.M{"state": state})
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []storedOrder
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	orders := make([]LimitOrder, 0, len(docs))
+	for _, d := range docs {
+		orders = append(orders, d.Order)
+	}
+	return orders, nil
+}
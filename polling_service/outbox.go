@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Quote server hits are written to an outbox collection first and delivered
+// to the transaction server's /log_qs_hit by a single goroutine, oldest first.
+// A failed delivery is retried with exponential backoff before anything newer
+// is sent, so the audit log keeps the order the hits happened in and nothing
+// is lost while the transaction server is down or this service restarts.
+
+const (
+	OUTBOX_MIN_BACKOFF = 100 * time.Millisecond
+	OUTBOX_MAX_BACKOFF = 30 * time.Second
+	OUTBOX_IDLE_POLL   = 5 * time.Second
+
+	// Tries at queueing a hit before the quote it records is given up on
+	OUTBOX_INSERT_ATTEMPTS = 3
+)
+
+type outboxEntry struct {
+	ID  primitive.ObjectID `bson:"_id"`
+	Hit logQSHit           `bson:"hit"`
+}
+
+var outbox_collection *mongo.Collection
+
+// Wakes the deliverer when something new is queued
+var outbox_wake = make(chan struct{}, 1)
+
+// Queues a quote server hit for delivery to the transaction server. A quote
+// whose hit can't be queued must not be used, as it would be missing from the
+// audit log.
+func log_qs_hit(hit logQSHit) error {
+	var err error
+	backoff := OUTBOX_MIN_BACKOFF
+	for attempt := 0; attempt < OUTBOX_INSERT_ATTEMPTS; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff = next_backoff(backoff)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err = outbox_collection.InsertOne(ctx, outboxEntry{ID: primitive.NewObjectID(), Hit: hit})
+		cancel()
+		if err == nil {
+			break
+		}
+		log.Printf("queueing quote server hit: %s\n", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	select {
+	case outbox_wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Delivers queued hits forever
+func deliver_outbox(transactionService string) {
+	backoff := OUTBOX_MIN_BACKOFF
+	for {
+		entry, found, err := next_outbox_entry()
+		if err != nil {
+			log.Printf("reading outbox: %s\n", err)
+			time.Sleep(backoff)
+			backoff = next_backoff(backoff)
+			continue
+		}
+
+		if !found {
+			select {
+			case <-outbox_wake:
+			case <-time.After(OUTBOX_IDLE_POLL):
+			}
+			continue
+		}
+
+		delivered, retry := send_qs_hit(transactionService, entry.Hit)
+		if !delivered && retry {
+			time.Sleep(backoff)
+			backoff = next_backoff(backoff)
+			continue
+		}
+		if !delivered {
+			log.Printf("dropping undeliverable quote server hit %s\n", entry.ID.Hex())
+		}
+
+		if err := remove_outbox_entry(entry.ID); err != nil {
+			log.Printf("removing outbox entry: %s\n", err)
+			continue
+		}
+		backoff = OUTBOX_MIN_BACKOFF
+	}
+}
+
+func next_backoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > OUTBOX_MAX_BACKOFF {
+		return OUTBOX_MAX_BACKOFF
+	}
+	return backoff
+}
+
+func next_outbox_entry() (outboxEntry, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var entry outboxEntry
+	opts := options.FindOne().SetSort(bson.M{"_id": 1})
+	err := outbox_collection.FindOne(ctx, bson.M{}, opts).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return entry, false, nil
+	}
+	if err != nil {
+		return entry, false, err
+	}
+	return entry, true, nil
+}
+
+func remove_outbox_entry(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := outbox_collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// Posts a hit to the transaction server. The transaction server only replies
+// 4xx to a hit it can't read, which sending it again won't change, so that is
+// reported as not worth retrying and the hit is dropped: as hits are delivered
+// in order, keeping it would hold up every hit queued after it.
+func send_qs_hit(transactionService string, hit logQSHit) (delivered bool, retry bool) {
+	parsedJson, err := json.Marshal(hit)
+	if err != nil {
+		return false, false
+	}
+
+	req, err := http.NewRequest(http.MethodPost, transactionService+"/log_qs_hit", bytes.NewBuffer(parsedJson))
+	if err != nil {
+		return false, false
+	}
+
+	// Timed out so a transaction server that accepts the connection but never
+	// answers is retried rather than stalling delivery
+	res, err := transaction_http_client.Do(req)
+	if err != nil {
+		log.Printf("delivering quote server hit: %s\n", err)
+		return false, true
+	}
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+
+	switch {
+	case res.StatusCode < 300:
+		return true, false
+	case res.StatusCode < 500:
+		log.Printf("delivering quote server hit: %s\n", res.Status)
+		return false, false
+	default:
+		log.Printf("delivering quote server hit: %s\n", res.Status)
+		return false, true
+	}
+}
@@ -1,18 +1,20 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"cache"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net"
 	"os"
-	"strconv"
-	"strings"
+	"quoteclient"
+	"sync"
 
 	"net/http"
 	"time"
@@ -27,17 +29,42 @@ type LimitOrder struct {
 	Amount float64
 	User   string `json:"ID"`
 	Qty    float64
+
+	// Identifies this arming of the trigger to the transaction server, which
+	// commits an order with a given id at most once
+	OrderId string
 }
 
 type req struct {
 	Sym      string `json:"Sym"`
 	Username string `json:"Username"`
+
+	// The transaction server command the quote is for, recorded with its hit
+	TransactionNum int `json:"TransactionNum"`
 }
 
 type quote_hit struct {
 	Timestamp int     `json:"Timestamp"`
 	Price     float64 `json:"Price"`
 	Cryptokey string  `json:"Cryptokey"`
+	Sym       string  `json:"Sym"`
+	User      string  `json:"User"` // user the quote server signed the quote for
+
+	// Seconds since the quote was made, and when it stops being valid (unix ms)
+	Age       float64 `json:"Age"`
+	ExpiresAt int64   `json:"ExpiresAt"`
+}
+
+// The record kept in the cache for q
+func (q quote_hit) cached() cache.Quote {
+	return cache.Quote{Price: q.Price, Sym: q.Sym, Timestamp: int64(q.Timestamp), Cryptokey: q.Cryptokey, User: q.User}
+}
+
+// Fills in the quote's age and expiry as of now
+func (q quote_hit) with_validity(now time.Time) quote_hit {
+	q.Age = q.cached().Age(now).Seconds()
+	q.ExpiresAt = q.cached().ExpiresAt().UnixMilli()
+	return q
 }
 
 type logQSHit struct {
@@ -46,9 +73,30 @@ type logQSHit struct {
 	Timestamp int     `json:"timestamp"`
 	Price     float64 `json:"price"`
 	Cryptokey string  `json:"cryptokey"`
+
+	// Zero for quotes fetched to evaluate triggers rather than for a command
+	TransactionNum int `json:"transactionNum"`
 }
 
+var quote_client *quoteclient.Client
+var quote_cache *cache.Metered
+
+// Triggers that have been armed by the transaction server and are waiting on
+// their price point. Guarded by active_orders_mu.
 var active_orders []LimitOrder
+var active_orders_mu sync.Mutex
+
+// Timing of the most recent evaluation pass, exposed on /tick_stats. When
+// streaming, a pass is the evaluation of the orders on one pushed price.
+type tickStats struct {
+	Orders     int     `json:"orders"`
+	Symbols    int     `json:"symbols"`
+	DurationMs float64 `json:"durationMs"`
+	Timestamp  int64   `json:"timestamp"`
+}
+
+var last_tick tickStats
+var last_tick_mu sync.Mutex
 
 func main() {
 	quoteServer, found := os.LookupEnv("QUOTE_SERVER")
@@ -64,70 +112,116 @@ func main() {
 	router.SetTrustedProxies(nil)
 
 	router.Use(func(ctx *gin.Context) {
-		ctx.Set("quoteServer", quoteServer)
 		ctx.Set("transactionService", transactionService)
 		ctx.Next()
 	})
 
 	router.POST("/new_limit", new_limit)
 	router.POST("/quote", get_price)
+	router.GET("/market/:symbol", get_market_status)
+	router.GET("/active_orders/:id", list_active_orders)
+	router.GET("/active_orders/:id/:type/:stock", get_active_order)
+	router.DELETE("/active_orders/:id/:type/:stock", cancel_active_order)
+	router.GET("/tick_stats", get_tick_stats)
+	router.GET("/metrics", get_metrics)
+	router.GET("/quotes/:symbol/history", get_quote_history)
+	router.GET("/quotes/:symbol/candles", get_quote_candles)
+
 	bind := flag.String("bind", "localhost:8081", "host:port to listen on")
+	tick := flag.Duration("tick", 1*time.Second, "interval between trigger evaluations")
+	concurrency := flag.Int("quote-concurrency", 8, "maximum symbols quoted in parallel per tick")
+	stream := flag.Bool("stream", false, "evaluate triggers on prices pushed by the quote server instead of polling")
+	streamUser := flag.String("stream-user", "polling_service", "user the quote server pushes prices for")
+	quoteConns := flag.Int("quote-conns", 4, "connections kept open to the quote server")
+	quoteTimeout := flag.Duration("quote-timeout", 5*time.Second, "deadline for a single quote server request")
 	flag.Parse()
 
-	if err := router.Run(*bind); err != nil {
-		panic(err)
+	if *concurrency < 1 {
+		log.Fatalln("-quote-concurrency must be at least 1")
 	}
 
-}
-
-func quote_price(servAddr string, sym string, username string) (quote_hit, error) {
-	strEcho := sym + " " + username + "\n"
+	quote_client = quoteclient.NewClient(quoteServer, *quoteConns, *quoteTimeout)
+	defer quote_client.Close()
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", servAddr)
+	var err error
+	quote_cache, err = cache.OpenFromEnv(context.Background())
 	if err != nil {
-		fmt.Println("\nResolveTCPAddr error: ", err)
-		return quote_hit{}, err
+		log.Fatalln(err)
+	}
+	defer quote_cache.Close()
+
+	databaseUri, found := os.LookupEnv("DATABASE_URI")
+	if !found {
+		log.Fatalln("No DATABASE_URI")
 	}
 
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
+	mongoClient, err := connectDb(databaseUri)
 	if err != nil {
-		fmt.Println("\nDialTCP error: ", err)
-		return quote_hit{}, err
+		log.Fatalln(err)
+	}
+	orders_collection = mongoClient.Database("daytrading").Collection("active_orders")
+	outbox_collection = mongoClient.Database("daytrading").Collection("qs_hit_outbox")
+	if err := setup_quote_history(mongoClient.Database("daytrading")); err != nil {
+		log.Fatalln(err)
 	}
 
-	defer conn.Close()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := mongoClient.Disconnect(ctx); err != nil {
+			panic(err)
+		}
+	}()
 
-	//write to server SYM being requested and user
-	_, err = conn.Write([]byte(strEcho))
+	// Resume evaluating the triggers that were armed before the restart
+	active_orders, err = load_orders(ORDER_ARMED)
 	if err != nil {
-		fmt.Println("\nWrite error: ", err)
-		return quote_hit{}, err
+		log.Fatalln(err)
+	}
+	if len(active_orders) > 0 {
+		log.Printf("Resuming %d active orders\n", len(active_orders))
 	}
 
-	reader := bufio.NewReader(conn)
-	replyLine, err := reader.ReadString('\n')
-	if err != nil {
-		fmt.Println("\nRead error: ", err)
-		return quote_hit{}, err
+	// Orders interrupted mid-fire are armed again rather than lost. They are
+	// only re-sent if the price still meets the trigger.
+	if err := reconcile_firing_orders(); err != nil {
+		log.Fatalln(err)
 	}
 
-	//parsing reply from server
-	reply := strings.Split(strings.TrimRight(replyLine, "\n"), ",")
-	quotePrice, err := strconv.ParseFloat(reply[0], 64)
-	if err != nil {
-		return quote_hit{}, err
+	go deliver_outbox(transactionService)
+	if *stream {
+		sub, err := quoteclient.NewSubscriber(quoteServer, *streamUser)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer sub.Close()
+		go stream_limit_orders(transactionService, sub, *streamUser, *tick)
+	} else {
+		go do_limit_order(transactionService, *tick, *concurrency)
+	}
+
+	if err := router.Run(*bind); err != nil {
+		panic(err)
 	}
-	timestamp, err := strconv.Atoi(reply[3])
+
+}
+
+func quote_price(sym string, username string) (quote_hit, error) {
+	q, err := quote_client.Quote(context.Background(), sym, username)
 	if err != nil {
 		return quote_hit{}, err
 	}
-	cryptKey := reply[4]
 
-	return quote_hit{
-		Price: quotePrice,
-		Timestamp: timestamp,
-		Cryptokey: cryptKey,
-	}, nil
+	hit := quote_hit{
+		Price:     q.Price,
+		Timestamp: q.Timestamp,
+		Cryptokey: q.Cryptokey,
+		Sym:       q.Sym,
+		User:      q.User,
+	}
+	go record_quote(sym, hit)
+
+	return hit, nil
 }
 
 func get_price(c *gin.Context) {
@@ -137,106 +231,408 @@ func get_price(c *gin.Context) {
 		return
 	}
 
-	q, err := quote_price(c.MustGet("quoteServer").(string), quote_req.Sym, quote_req.Username)
+	q, shared, err := coalesced_quote(quote_req.Sym, quote_req.Username)
+	if errors.Is(err, quoteclient.ErrInvalidRequest) {
+		c.IndentedJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if status, found := market_status_of(err); found {
+		reply_market_status(c, status)
+		return
+	}
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, err)
+		c.IndentedJSON(http.StatusBadGateway, err.Error())
 		return
 	}
 
-	cache.SetKeyWithExpirationInSecs(quote_req.Sym, q.Price, 0)
+	// Logging quote server hit, attributed to this user even when the
+	// request was shared with others
+	hit := logQSHit{Id: quote_req.Username, Sym: quote_req.Sym, Timestamp: q.Timestamp, Price: q.Price, Cryptokey: q.Cryptokey, TransactionNum: quote_req.TransactionNum}
+	if err := log_qs_hit(hit); err != nil {
+		c.IndentedJSON(http.StatusServiceUnavailable, "quote server hit could not be logged")
+		return
+	}
 
-	c.IndentedJSON(http.StatusOK, q)
+	if !shared {
+		quote_cache.SetQuote(c.Request.Context(), q.cached())
+	}
+
+	c.IndentedJSON(http.StatusOK, q.with_validity(time.Now()))
+}
+
+type market_status struct {
+	Symbol string `json:"Symbol"`
+	Status string `json:"Status"`
+}
+
+// Whether the symbol is trading, so prices cached elsewhere aren't traded on
+// while its market is closed or halted
+func get_market_status(c *gin.Context) {
+	sym := c.Param("symbol")
+
+	status, err := quote_client.Status(context.Background(), sym)
+	if errors.Is(err, quoteclient.ErrInvalidRequest) {
+		c.IndentedJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusBadGateway, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, market_status{Symbol: sym, Status: status})
+}
+
+// The market status a quote was refused with, if it was refused because its
+// symbol isn't trading
+func market_status_of(err error) (string, bool) {
+	var marketErr *quoteclient.MarketError
+	if errors.As(err, &marketErr) {
+		return marketErr.Status, true
+	}
+	return "", false
+}
+
+// Replies to a quote refused by the quote server with the symbol's status:
+// 404 for symbols it doesn't know, 409 for ones that aren't trading right now
+func reply_market_status(c *gin.Context, status string) {
+	if status == quoteclient.MARKET_UNKNOWN {
+		c.IndentedJSON(http.StatusNotFound, status)
+		return
+	}
+	c.IndentedJSON(http.StatusConflict, status)
+}
+
+// Evaluates every armed order once per tick
+func do_limit_order(transactionService string, tick time.Duration, concurrency int) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		evaluate_orders(transactionService, concurrency)
+	}
+}
+
+// Groups the armed orders by symbol so each symbol is quoted once, with at
+// most concurrency symbols being quoted at the same time
+func evaluate_orders(transactionService string, concurrency int) {
+	start := time.Now()
+
+	active_orders_mu.Lock()
+	bySymbol := make(map[string][]LimitOrder)
+	for _, o := range active_orders {
+		bySymbol[o.Stock] = append(bySymbol[o.Stock], o)
+	}
+	numOrders := len(active_orders)
+	active_orders_mu.Unlock()
+
+	if numOrders == 0 {
+		return
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for sym, orders := range bySymbol {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(sym string, orders []LimitOrder) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			evaluate_symbol(transactionService, sym, orders)
+		}(sym, orders)
+	}
+	wg.Wait()
+
+	record_tick(numOrders, len(bySymbol), start)
 }
 
-func do_limit_order(quoteServer string, transactionService string) {
-	j := 0
-	for len(active_orders) > 0 {
-		// do: update cache
-		val, err := quote_price(quoteServer, active_orders[j].Stock, active_orders[j].User)
+func record_tick(orders int, symbols int, start time.Time) {
+	last_tick_mu.Lock()
+	defer last_tick_mu.Unlock()
 
+	last_tick = tickStats{Orders: orders, Symbols: symbols, DurationMs: float64(time.Since(start).Microseconds()) / 1000, Timestamp: start.Unix()}
+}
+
+// Fetches a single quote for sym and tests every order on it against that price
+func evaluate_symbol(transactionService string, sym string, orders []LimitOrder) {
+	// The quote is attributed to the first user waiting on this symbol
+	val, _, err := coalesced_quote(sym, orders[0].User)
+	if status, found := market_status_of(err); found {
+		// The orders stay armed until the symbol trades again
+		log.Printf("not evaluating %d orders on %s: %s\n", len(orders), sym, status)
+		return
+	}
+	if err != nil {
+		log.Printf("fetching quote price: %s\n", err)
+		return
+	}
+
+	// Logging quote server hit; the orders wait for the next tick if it can't be
+	if err := log_qs_hit(logQSHit{Id: orders[0].User, Sym: sym, Timestamp: val.Timestamp, Price: val.Price, Cryptokey: val.Cryptokey}); err != nil {
+		return
+	}
+
+	evaluate_quote(transactionService, sym, orders, val)
+}
+
+// Tests every order on sym against the quote, firing the ones it triggers
+func evaluate_quote(transactionService string, sym string, orders []LimitOrder, val quote_hit) {
+	cached := false
+	for _, o := range orders {
+		if !triggered(o, val.Price) {
+			continue
+		}
+
+		// The order may have been cancelled while we were waiting on the
+		// quote server; only fire it if we manage to claim it.
+		stored, claimed, err := claim_order(o)
 		if err != nil {
-			log.Printf("fetching quote price: %s\n", err)
-		} else {
-			// Logging quote server hit
-			logQSHit_ := logQSHit{Id: active_orders[j].User, Sym: active_orders[j].Stock, Timestamp: val.Timestamp, Price: val.Price, Cryptokey: val.Cryptokey}
-			parsedJson, _ := json.Marshal(logQSHit_)
-			_, err = http.NewRequest(http.MethodPost, transactionService+"/log_qs_hit", bytes.NewBuffer(parsedJson))
-			if err != nil {
-				fmt.Println("ERROR")
-				log.Fatal(err)
-			}
-
-			if val.Price > active_orders[j].Price && active_orders[j].Type == "sell" {
-				cache.SetKeyWithExpirationInSecs(active_orders[j].Stock, val.Price, 0)
-				active_orders[j].Qty = active_orders[j].Amount
-
-				parsedJson, _ := json.Marshal(active_orders[j])
-				req, err := http.NewRequest(http.MethodPost, transactionService+"/users/sell", bytes.NewBuffer(parsedJson))
-				_, err = http.DefaultClient.Do(req)
-
-				req, err = http.NewRequest(http.MethodPost, transactionService+"/users/sell/commit", bytes.NewBuffer(parsedJson))
-				_, err = http.DefaultClient.Do(req)
-
-				if err != nil {
-					fmt.Println("ERROR")
-					fmt.Println(err)
-				}
-
-				active_orders = append(active_orders[:j], active_orders[j+1:]...)
-
-			} else if val.Price < active_orders[j].Price && active_orders[j].Type == "buy" {
-				cache.SetKeyWithExpirationInSecs(active_orders[j].Stock, val.Price, 0)
-				active_orders[j].Qty = active_orders[j].Amount
-
-				parsedJson, _ := json.Marshal(active_orders[j])
-				req, err := http.NewRequest(http.MethodPost, transactionService+"/users/buy", bytes.NewBuffer(parsedJson))
-				res, err := http.DefaultClient.Do(req)
-
-				_, err = ioutil.ReadAll(res.Body)
-				if err != nil {
-					fmt.Println("ERROR")
-					fmt.Println(err)
-				}
-
-				req, err = http.NewRequest(http.MethodPost, transactionService+"/users/buy/commit", bytes.NewBuffer(parsedJson))
-				res, err = http.DefaultClient.Do(req)
-				_, err = ioutil.ReadAll(res.Body)
-				if err != nil {
-					fmt.Println("ERROR")
-					fmt.Println(err)
-				}
-
-				active_orders = append(active_orders[:j], active_orders[j+1:]...)
-			}
-		}
-
-		time.Sleep(1 * time.Second) // Math goes here
-		if len(active_orders) < 1 {
-			return
+			log.Printf("claiming order: %s\n", err)
+			continue
+		}
+		remove_active_order(o.User, o.Type, o.Stock)
+		if !claimed {
+			continue
+		}
+
+		// Set again since it was read, and the new trigger isn't met
+		if !triggered(stored, val.Price) {
+			rearm(stored)
+			continue
+		}
+
+		if !cached {
+			quote_cache.SetQuote(context.Background(), val.cached())
+			cached = true
+		}
+		err = fire_limit_order(transactionService, stored)
+		var refused *orderRefused
+		if err != nil && !errors.As(err, &refused) {
+			log.Printf("firing order %s: %s, re-arming\n", order_key(stored.User, stored.Type, stored.Stock), err)
+			rearm(stored)
+			continue
+		}
+		if err != nil {
+			log.Printf("firing order %s: %s\n", order_key(stored.User, stored.Type, stored.Stock), err)
+		}
+		if err := delete_order(stored); err != nil {
+			log.Printf("deleting fired order: %s\n", err)
 		}
-		j = (j + 1) % len(active_orders)
 	}
+}
 
-	return
+// Whether the price meets the order's trigger
+func triggered(o LimitOrder, price float64) bool {
+	return (price > o.Price && o.Type == "sell") || (price < o.Price && o.Type == "buy")
 }
 
-func new_limit(c *gin.Context) {
-	quoteServer := c.MustGet("quoteServer").(string)
-	transactionService := c.MustGet("transactionService").(string)
+// Puts a claimed order back to be evaluated again
+func rearm(o LimitOrder) {
+	if err := rearm_order(o); err != nil {
+		log.Printf("re-arming order: %s\n", err)
+	}
+	add_active_order(o)
+}
+
+// Re-arms the orders left firing by a crash and adds them to active_orders
+func reconcile_firing_orders() error {
+	firing, err := load_orders(ORDER_FIRING)
+	if err != nil {
+		return err
+	}
+	for _, o := range firing {
+		log.Printf("Order %s was interrupted while firing, re-arming\n", order_key(o.User, o.Type, o.Stock))
+		if err := rearm_order(o); err != nil {
+			return err
+		}
+		add_active_order(o)
+	}
+	return nil
+}
+
+func get_tick_stats(c *gin.Context) {
+	last_tick_mu.Lock()
+	defer last_tick_mu.Unlock()
+
+	c.IndentedJSON(http.StatusOK, last_tick)
+}
+
+func get_metrics(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, gin.H{"cache": quote_cache.Metrics()})
+}
+
+// Requests to the transaction server give up after this long, so one that
+// stops answering can't hold up trigger evaluation or the outbox
+var transaction_http_client = &http.Client{Timeout: 10 * time.Second}
+
+// A fired order the transaction server turned down, e.g. for lack of funds.
+// Sending it again won't change the answer.
+type orderRefused struct {
+	url    string
+	status string
+	reason string
+}
+
+func (e *orderRefused) Error() string {
+	return e.url + " refused order: " + e.status + " " + e.reason
+}
+
+// Sends the triggered order to the transaction server as a buy/sell followed
+// by its commit, which is only sent if the buy/sell was accepted. An
+// orderRefused error is final; any other error means the order may not have
+// gone through and should be sent again.
+func fire_limit_order(transactionService string, o LimitOrder) error {
+	o.Qty = o.Amount
+
+	parsedJson, _ := json.Marshal(o)
+	if err := post_order(transactionService+"/users/"+o.Type, parsedJson); err != nil {
+		return err
+	}
+	return post_order(transactionService+"/users/"+o.Type+"/commit", parsedJson)
+}
+
+func post_order(url string, body []byte) error {
+	res, err := transaction_http_client.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	msg, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return nil
+	// The transaction server failing, or the user's lock being busy, passes
+	case res.StatusCode >= 500 || res.StatusCode == http.StatusConflict:
+		return fmt.Errorf("%s: %s %s", url, res.Status, msg)
+	}
+	return &orderRefused{url: url, status: res.Status, reason: string(msg)}
+}
+
+// Removes and returns the in-memory order matching user, type and stock
+func remove_active_order(user string, orderType string, stock string) (LimitOrder, bool) {
+	active_orders_mu.Lock()
+	defer active_orders_mu.Unlock()
+
+	for j, o := range active_orders {
+		if o.User == user && o.Type == orderType && o.Stock == stock {
+			active_orders = append(active_orders[:j], active_orders[j+1:]...)
+			return o, true
+		}
+	}
+	return LimitOrder{}, false
+}
 
+func new_limit(c *gin.Context) {
 	var limitorder LimitOrder
 	if err := c.BindJSON(&limitorder); err != nil {
 		c.IndentedJSON(http.StatusOK, err)
 		return
 	}
 
+	orderId, err := new_order_id()
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	limitorder.OrderId = orderId
+
+	// The order is only acknowledged once it is durable
+	err = store_order(limitorder)
+	if err == ErrOrderFiring {
+		c.IndentedJSON(http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	c.IndentedJSON(http.StatusOK, "ok")
+	add_active_order(limitorder)
+}
+
+func new_order_id() (string, error) {
+	idBuf := make([]byte, 16)
+	if _, err := rand.Read(idBuf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(idBuf), nil
+}
+
+// Adds an armed order to active_orders. Re-arming a trigger for the same user,
+// type and stock replaces it.
+func add_active_order(limitorder LimitOrder) {
+	active_orders_mu.Lock()
+	defer active_orders_mu.Unlock()
+
+	for j, o := range active_orders {
+		if o.User == limitorder.User && o.Type == limitorder.Type && o.Stock == limitorder.Stock {
+			active_orders[j] = limitorder
+			return
+		}
+	}
 
 	active_orders = append(active_orders, limitorder)
-	if len(active_orders) == 1 {
-		go do_limit_order(quoteServer, transactionService)
-	} else {
+}
+
+// Lists the user's armed orders, optionally filtered by the type and stock
+// query parameters
+func list_active_orders(c *gin.Context) {
+	id := c.Param("id")
+	orderType := c.Query("type")
+	stock := c.Query("stock")
+
+	active_orders_mu.Lock()
+	defer active_orders_mu.Unlock()
+
+	orders := []LimitOrder{}
+	for _, o := range active_orders {
+		if o.User != id {
+			continue
+		}
+		if orderType != "" && o.Type != orderType {
+			continue
+		}
+		if stock != "" && o.Stock != stock {
+			continue
+		}
+		orders = append(orders, o)
+	}
+
+	c.IndentedJSON(http.StatusOK, orders)
+}
+
+func get_active_order(c *gin.Context) {
+	id := c.Param("id")
+	orderType := c.Param("type")
+	stock := c.Param("stock")
+
+	active_orders_mu.Lock()
+	defer active_orders_mu.Unlock()
+
+	for _, o := range active_orders {
+		if o.User == id && o.Type == orderType && o.Stock == stock {
+			c.IndentedJSON(http.StatusOK, o)
+			return
+		}
+	}
+
+	c.IndentedJSON(http.StatusNotFound, "No active order")
+}
+
+func cancel_active_order(c *gin.Context) {
+	found, err := cancel_stored_order(c.Param("id"), c.Param("type"), c.Param("stock"))
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	o, _ := remove_active_order(c.Param("id"), c.Param("type"), c.Param("stock"))
+	if !found {
+		c.IndentedJSON(http.StatusNotFound, "No active order")
 		return
 	}
 
+	c.IndentedJSON(http.StatusOK, o)
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Every quote the polling service obtains from the quote server is kept in a
+// time-series collection, bucketed by symbol, so price history and candles
+// can be served without hitting the quote server again.
+
+const QUOTE_HISTORY_LIMIT = 1000
+
+type quoteRecord struct {
+	Symbol    string    `bson:"symbol" json:"symbol"`
+	Price     float64   `bson:"price" json:"price"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"` // quote server time
+	Cryptokey string    `bson:"cryptokey" json:"cryptokey"`
+}
+
+type candle struct {
+	Start time.Time `bson:"_id" json:"start"`
+	Open  float64   `bson:"open" json:"open"`
+	High  float64   `bson:"high" json:"high"`
+	Low   float64   `bson:"low" json:"low"`
+	Close float64   `bson:"close" json:"close"`
+	Count int       `bson:"count" json:"count"`
+}
+
+var history_collection *mongo.Collection
+
+// Creates the time-series collection if it does not exist yet
+func setup_quote_history(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	history_collection = db.Collection("quote_history")
+
+	tsOpts := options.TimeSeries().SetTimeField("timestamp").SetMetaField("symbol").SetGranularity("seconds")
+	err := db.CreateCollection(ctx, "quote_history", options.CreateCollection().SetTimeSeriesOptions(tsOpts))
+	if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Name == "NamespaceExists" {
+		return nil
+	}
+	return err
+}
+
+func record_quote(sym string, q quote_hit) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rec := quoteRecord{Symbol: sym, Price: q.Price, Timestamp: time.UnixMilli(int64(q.Timestamp)), Cryptokey: q.Cryptokey}
+	if _, err := history_collection.InsertOne(ctx, rec); err != nil {
+		log.Printf("recording quote: %s\n", err)
+	}
+}
+
+// Reads the optional from/to query parameters (unix seconds), defaulting to
+// the last 24 hours
+func history_range(c *gin.Context) (time.Time, time.Time, bool) {
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+
+	if v := c.Query("from"); v != "" {
+		secs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return from, to, false
+		}
+		from = time.Unix(secs, 0)
+	}
+	if v := c.Query("to"); v != "" {
+		secs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return from, to, false
+		}
+		to = time.Unix(secs, 0)
+	}
+	return from, to, true
+}
+
+func get_quote_history(c *gin.Context) {
+	sym := c.Param("symbol")
+
+	from, to, ok := history_range(c)
+	if !ok {
+		c.IndentedJSON(http.StatusBadRequest, "Bad request")
+		return
+	}
+
+	limit := int64(QUOTE_HISTORY_LIMIT)
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > QUOTE_HISTORY_LIMIT {
+			c.IndentedJSON(http.StatusBadRequest, "Bad request")
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"symbol": sym, "timestamp": bson.M{"$gte": from, "$lt": to}}
+	opts := options.Find().SetSort(bson.M{"timestamp": 1}).SetLimit(limit).SetProjection(bson.M{"_id": 0})
+	cursor, err := history_collection.Find(ctx, filter, opts)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	records := []quoteRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, records)
+}
+
+// Aggregates the symbol's history into open/high/low/close candles of the
+// given interval (e.g. 30s, 1m, 1h)
+func get_quote_candles(c *gin.Context) {
+	sym := c.Param("symbol")
+
+	interval, err := time.ParseDuration(c.DefaultQuery("interval", "1m"))
+	if err != nil || interval < time.Second || interval%time.Second != 0 {
+		c.IndentedJSON(http.StatusBadRequest, "Bad interval")
+		return
+	}
+
+	from, to, ok := history_range(c)
+	if !ok {
+		c.IndentedJSON(http.StatusBadRequest, "Bad request")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"symbol": sym, "timestamp": bson.M{"$gte": from, "$lt": to}}}},
+		{{Key: "$sort", Value: bson.M{"timestamp": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateTrunc": bson.M{"date": "$timestamp", "unit": "second", "binSize": int64(interval / time.Second)}},
+			"open":  bson.M{"$first": "$price"},
+			"high":  bson.M{"$max": "$price"},
+			"low":   bson.M{"$min": "$price"},
+			"close": bson.M{"$last": "$price"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+
+	cursor, err := history_collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	candles := []candle{}
+	if err := cursor.All(ctx, &candles); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, candles)
+}
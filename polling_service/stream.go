@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"quoteclient"
+	"time"
+)
+
+// Evaluates triggers on prices pushed by the quote server rather than by
+// polling it. Subscriptions are kept in line with the symbols of the armed
+// orders once per tick.
+func stream_limit_orders(transactionService string, sub *quoteclient.Subscriber, streamUser string, tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	sync_subscriptions(sub)
+	for {
+		select {
+		case <-ticker.C:
+			sync_subscriptions(sub)
+		case q, ok := <-sub.Updates():
+			if !ok {
+				return
+			}
+
+			val := quote_hit{Price: q.Price, Timestamp: q.Timestamp, Cryptokey: q.Cryptokey, Sym: q.Sym, User: q.User}
+			go record_quote(q.Sym, val)
+
+			// Logging quote server hit; the orders wait for the next push if it
+			// can't be
+			if err := log_qs_hit(logQSHit{Id: streamUser, Sym: q.Sym, Timestamp: val.Timestamp, Price: val.Price, Cryptokey: val.Cryptokey}); err != nil {
+				continue
+			}
+
+			start := time.Now()
+			active_orders_mu.Lock()
+			var orders []LimitOrder
+			for _, o := range active_orders {
+				if o.Stock == q.Sym {
+					orders = append(orders, o)
+				}
+			}
+			active_orders_mu.Unlock()
+
+			if len(orders) > 0 {
+				evaluate_quote(transactionService, q.Sym, orders, val)
+				record_tick(len(orders), 1, start)
+			}
+		}
+	}
+}
+
+// Subscribes to every symbol with an armed order and drops the rest
+func sync_subscriptions(sub *quoteclient.Subscriber) {
+	active_orders_mu.Lock()
+	wanted := make(map[string]bool)
+	for _, o := range active_orders {
+		wanted[o.Stock] = true
+	}
+	active_orders_mu.Unlock()
+
+	for _, sym := range sub.Symbols() {
+		if !wanted[sym] {
+			if err := sub.Unsubscribe(sym); err != nil {
+				log.Printf("unsubscribing from %s: %s\n", sym, err)
+			}
+		}
+		delete(wanted, sym)
+	}
+
+	for sym := range wanted {
+		if err := sub.Subscribe(sym); err != nil {
+			log.Printf("subscribing to %s: %s\n", sym, err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	mathRand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Faults injected into replies to imitate the real quote server, which is slow
+// and unreliable. Every request first waits for a delay drawn from the latency
+// distribution and then, with the configured probabilities, has its connection
+// dropped, gets a malformed line back or stalls before answering.
+
+const (
+	FAULT_NONE = iota
+	FAULT_DROP
+	FAULT_MALFORMED
+	FAULT_STALL
+)
+
+type latencyDist struct {
+	kind string
+	a, b time.Duration
+}
+
+type faultConfig struct {
+	latency       latencyDist
+	dropRate      float64
+	malformedRate float64
+	stallRate     float64
+	stallDuration time.Duration
+}
+
+// Set by the fault flags
+var faults faultConfig
+
+var faultRng *mathRand.Rand
+var faultRngMu sync.Mutex
+
+// Parses a latency distribution:
+//
+//	none, fixed:D, uniform:MIN-MAX, normal:MEAN,STDDEV or exp:MEAN
+func parseLatency(spec string) (latencyDist, error) {
+	if spec == "" || spec == "none" {
+		return latencyDist{kind: "none"}, nil
+	}
+
+	kind, args, found := strings.Cut(spec, ":")
+	if !found {
+		return latencyDist{}, fmt.Errorf("bad latency %q", spec)
+	}
+
+	var sep string
+	switch kind {
+	case "fixed", "exp":
+		d, err := time.ParseDuration(args)
+		if err != nil {
+			return latencyDist{}, err
+		}
+		return latencyDist{kind: kind, a: d}, nil
+	case "uniform":
+		sep = "-"
+	case "normal":
+		sep = ","
+	default:
+		return latencyDist{}, fmt.Errorf("unknown latency distribution %q", kind)
+	}
+
+	first, second, found := strings.Cut(args, sep)
+	if !found {
+		return latencyDist{}, fmt.Errorf("bad latency %q", spec)
+	}
+	a, err := time.ParseDuration(first)
+	if err != nil {
+		return latencyDist{}, err
+	}
+	b, err := time.ParseDuration(second)
+	if err != nil {
+		return latencyDist{}, err
+	}
+	if kind == "uniform" && b < a {
+		return latencyDist{}, fmt.Errorf("bad latency %q", spec)
+	}
+	return latencyDist{kind: kind, a: a, b: b}, nil
+}
+
+// Checks the fault probabilities are probabilities, and that together they
+// leave room for nothing going wrong
+func (f faultConfig) validate() error {
+	rates := []struct {
+		flag string
+		rate float64
+	}{{"drop-rate", f.dropRate}, {"malformed-rate", f.malformedRate}, {"stall-rate", f.stallRate}}
+
+	for _, r := range rates {
+		if !(r.rate >= 0 && r.rate <= 1) {
+			return fmt.Errorf("--%s must be between 0 and 1, got %v", r.flag, r.rate)
+		}
+	}
+	if f.dropRate+f.malformedRate+f.stallRate > 1 {
+		return errors.New("--drop-rate, --malformed-rate and --stall-rate must add up to at most 1")
+	}
+	if f.stallDuration < 0 {
+		return errors.New("--stall-duration must not be negative")
+	}
+	return nil
+}
+
+func setupFaults(rngSeed int64) {
+	faultRng = mathRand.New(mathRand.NewSource(rngSeed))
+}
+
+func (l latencyDist) sample() time.Duration {
+	faultRngMu.Lock()
+	defer faultRngMu.Unlock()
+
+	switch l.kind {
+	case "fixed":
+		return l.a
+	case "uniform":
+		return l.a + time.Duration(faultRng.Int63n(int64(l.b-l.a)+1))
+	case "normal":
+		d := float64(l.a) + faultRng.NormFloat64()*float64(l.b)
+		return time.Duration(math.Max(d, 0))
+	case "exp":
+		return time.Duration(faultRng.ExpFloat64() * float64(l.a))
+	}
+	return 0
+}
+
+// Picks which fault, if any, hits the next reply
+func (f faultConfig) draw() int {
+	faultRngMu.Lock()
+	defer faultRngMu.Unlock()
+
+	r := faultRng.Float64()
+	switch {
+	case r < f.dropRate:
+		return FAULT_DROP
+	case r < f.dropRate+f.malformedRate:
+		return FAULT_MALFORMED
+	case r < f.dropRate+f.malformedRate+f.stallRate:
+		return FAULT_STALL
+	}
+	return FAULT_NONE
+}
+
+// Mangles a well formed reply line
+func malform(response string) string {
+	faultRngMu.Lock()
+	n := faultRng.Intn(3)
+	faultRngMu.Unlock()
+
+	fields := strings.Split(strings.TrimSuffix(response, "\n"), ",")
+	switch n {
+	case 0:
+		// truncated
+		return strings.Join(fields[:2], ",") + "\n"
+	case 1:
+		// garbage price
+		fields[0] = "NaN"
+		return strings.Join(fields, ",") + "\n"
+	default:
+		// wrong timestamp
+		fields[3] = strconv.Itoa(-1)
+		return strings.Join(fields, ",") + "\n"
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseLatency(t *testing.T) {
+	tests := []struct {
+		spec string
+		want latencyDist
+	}{
+		{"", latencyDist{kind: "none"}},
+		{"none", latencyDist{kind: "none"}},
+		{"fixed:20ms", latencyDist{kind: "fixed", a: 20 * time.Millisecond}},
+		{"exp:1s", latencyDist{kind: "exp", a: time.Second}},
+		{"uniform:10ms-30ms", latencyDist{kind: "uniform", a: 10 * time.Millisecond, b: 30 * time.Millisecond}},
+		{"uniform:5ms-5ms", latencyDist{kind: "uniform", a: 5 * time.Millisecond, b: 5 * time.Millisecond}},
+		{"normal:100ms,15ms", latencyDist{kind: "normal", a: 100 * time.Millisecond, b: 15 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		got, err := parseLatency(tt.spec)
+		if err != nil {
+			t.Errorf("parseLatency(%q): %s", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseLatency(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseLatencyRejects(t *testing.T) {
+	for _, spec := range []string{
+		"fixed",
+		"fixed:",
+		"fixed:soon",
+		"gamma:1s",
+		"uniform:30ms",
+		"uniform:30ms-10ms",
+		"uniform:10ms,30ms",
+		"normal:100ms-15ms",
+		"normal:100ms,",
+	} {
+		if got, err := parseLatency(spec); err == nil {
+			t.Errorf("parseLatency(%q) = %+v, want an error", spec, got)
+		}
+	}
+}
+
+func TestLatencySample(t *testing.T) {
+	setupFaults(1)
+
+	uniform := latencyDist{kind: "uniform", a: 10 * time.Millisecond, b: 20 * time.Millisecond}
+	normal := latencyDist{kind: "normal", a: time.Millisecond, b: 10 * time.Millisecond}
+	for i := 0; i < 1000; i++ {
+		if d := uniform.sample(); d < uniform.a || d > uniform.b {
+			t.Fatalf("uniform sample %s outside [%s, %s]", d, uniform.a, uniform.b)
+		}
+		if d := normal.sample(); d < 0 {
+			t.Fatalf("negative normal sample %s", d)
+		}
+	}
+
+	if d := (latencyDist{kind: "fixed", a: time.Second}).sample(); d != time.Second {
+		t.Errorf("fixed sample = %s", d)
+	}
+	if d := (latencyDist{kind: "none"}).sample(); d != 0 {
+		t.Errorf("none sample = %s", d)
+	}
+}
+
+func TestFaultConfigValidate(t *testing.T) {
+	valid := []faultConfig{
+		{},
+		{dropRate: 1},
+		{dropRate: 0.2, malformedRate: 0.3, stallRate: 0.5, stallDuration: time.Second},
+	}
+	for _, f := range valid {
+		if err := f.validate(); err != nil {
+			t.Errorf("%+v: %s", f, err)
+		}
+	}
+
+	invalid := []faultConfig{
+		{dropRate: -0.1},
+		{malformedRate: 1.5},
+		{stallRate: math.NaN()},
+		{dropRate: math.Inf(1)},
+		{dropRate: 0.5, malformedRate: 0.5, stallRate: 0.5},
+		{stallDuration: -time.Second},
+	}
+	for _, f := range invalid {
+		if err := f.validate(); err == nil {
+			t.Errorf("%+v accepted", f)
+		}
+	}
+}
+
+func TestFaultDraw(t *testing.T) {
+	setupFaults(1)
+
+	if got := (faultConfig{}).draw(); got != FAULT_NONE {
+		t.Errorf("no faults configured, drew %d", got)
+	}
+	for _, tt := range []struct {
+		f    faultConfig
+		want int
+	}{
+		{faultConfig{dropRate: 1}, FAULT_DROP},
+		{faultConfig{malformedRate: 1}, FAULT_MALFORMED},
+		{faultConfig{stallRate: 1}, FAULT_STALL},
+	} {
+		if got := tt.f.draw(); got != tt.want {
+			t.Errorf("%+v drew %d, want %d", tt.f, got, tt.want)
+		}
+	}
+}
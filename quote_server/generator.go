@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	cryptoRand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	mathRand "math/rand"
+	"sync"
+)
+
+var errCounterOverflow = errors.New("Counter overflow")
+
+// AES in counter mode. Its first block seeds a math/rand source used for
+// price movements and the following blocks are handed out as cryptokeys.
+// Seeding it with a fixed key makes both fully reproducible.
+type generator struct {
+	mu         sync.Mutex
+	rng        cipher.Block
+	counter    uint32
+	counterBuf []byte
+	weakRng    *mathRand.Rand
+}
+
+func newGenerator(rngSeed []byte) *generator {
+	rng, err := aes.NewCipher(rngSeed)
+	if err != nil {
+		// this should never happen
+		panic(err)
+	}
+
+	g := &generator{rng: rng, counterBuf: make([]byte, 16)}
+
+	weakRngSeed := make([]byte, 16)
+	g.next(weakRngSeed)
+	g.weakRng = mathRand.New(mathRand.NewSource(int64(binary.LittleEndian.Uint64(weakRngSeed[:8]))))
+
+	return g
+}
+
+// A generator seeded from crypto/rand
+func newRandomGenerator() (*generator, error) {
+	rngSeed := make([]byte, 16)
+	if _, err := cryptoRand.Read(rngSeed); err != nil {
+		return nil, err
+	}
+	return newGenerator(rngSeed), nil
+}
+
+// A generator seeded from the --seed value and a label telling apart the
+// connection or symbol it belongs to
+func newSeededGenerator(seed int64, label string) *generator {
+	seedBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(seedBuf, uint64(seed))
+
+	h := sha256.New()
+	h.Write(seedBuf)
+	h.Write([]byte(label))
+	return newGenerator(h.Sum(nil)[:16])
+}
+
+func (g *generator) next(dst []byte) {
+	g.rng.Encrypt(dst, g.counterBuf)
+	g.counter += 1
+	binary.LittleEndian.PutUint32(g.counterBuf[:4], g.counter)
+}
+
+// Draws the next cryptokey
+func (g *generator) key() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.counter >= 0xffffff00 {
+		return "", errCounterOverflow
+	}
+
+	responseKeyBuf := make([]byte, 32)
+	g.next(responseKeyBuf[:16])
+	g.next(responseKeyBuf[16:])
+
+	return base64.RawURLEncoding.EncodeToString(responseKeyBuf[:20]), nil
+}
+
+// Draws a standard normal value from the math/rand source
+func (g *generator) normal() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.weakRng.NormFloat64()
+}
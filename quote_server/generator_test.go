@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The first n keys and normal draws of g
+func drawSequence(t *testing.T, g *generator, n int) ([]string, []float64) {
+	t.Helper()
+
+	var keys []string
+	var normals []float64
+	for i := 0; i < n; i++ {
+		key, err := g.key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		normals = append(normals, g.normal())
+	}
+	return keys, normals
+}
+
+func TestSeededGeneratorsRepeat(t *testing.T) {
+	keys, normals := drawSequence(t, newSeededGenerator(42, "conn:1"), 10)
+	againKeys, againNormals := drawSequence(t, newSeededGenerator(42, "conn:1"), 10)
+
+	if !reflect.DeepEqual(keys, againKeys) {
+		t.Errorf("keys differ for the same seed:\n%v\n%v", keys, againKeys)
+	}
+	if !reflect.DeepEqual(normals, againNormals) {
+		t.Errorf("normals differ for the same seed:\n%v\n%v", normals, againNormals)
+	}
+}
+
+func TestSeededGeneratorsDiffer(t *testing.T) {
+	keys, _ := drawSequence(t, newSeededGenerator(42, "conn:1"), 4)
+
+	for name, g := range map[string]*generator{
+		"other seed":  newSeededGenerator(43, "conn:1"),
+		"other label": newSeededGenerator(42, "conn:2"),
+	} {
+		if other, _ := drawSequence(t, g, 4); reflect.DeepEqual(keys, other) {
+			t.Errorf("%s: same keys as seed 42, conn:1", name)
+		}
+	}
+}
+
+// Runs f as if the server was started with --seed s, with no prices drawn yet
+func withSeed(t *testing.T, s int64, f func()) {
+	t.Helper()
+
+	oldSeeded, oldSeed, oldPrices := seeded, seed, symbolPrices
+	defer func() { seeded, seed, symbolPrices = oldSeeded, oldSeed, oldPrices }()
+
+	seeded, seed = true, s
+	symbolPrices = map[string]*symbolPrice{}
+	f()
+}
+
+// The first n prices and keys for sym, quoted as by a single connection
+func seededRun(t *testing.T, s int64, sym string, n int) ([]int, []string) {
+	t.Helper()
+
+	var prices []int
+	var keys []string
+	withSeed(t, s, func() {
+		conn, err := connGenerator(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &client{gen: conn}
+		for i := 0; i < n; i++ {
+			cents, err := nextPrice(sym)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := c.gen.key()
+			if err != nil {
+				t.Fatal(err)
+			}
+			prices = append(prices, cents)
+			keys = append(keys, key)
+		}
+	})
+	return prices, keys
+}
+
+func TestSeededRunsRepeat(t *testing.T) {
+	oldVolatility := volatility
+	defer func() { volatility = oldVolatility }()
+	volatility = 0.05
+
+	prices, keys := seededRun(t, 7, "ABC", 20)
+	againPrices, againKeys := seededRun(t, 7, "ABC", 20)
+
+	if !reflect.DeepEqual(prices, againPrices) {
+		t.Errorf("prices differ for the same seed:\n%v\n%v", prices, againPrices)
+	}
+	if !reflect.DeepEqual(keys, againKeys) {
+		t.Errorf("keys differ for the same seed:\n%v\n%v", keys, againKeys)
+	}
+
+	if otherPrices, _ := seededRun(t, 8, "ABC", 20); reflect.DeepEqual(prices, otherPrices) {
+		t.Error("same prices for another seed")
+	}
+}
@@ -1,3 +1,3 @@
 module quote_server
 
-go 1.19
+go 1.20
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// An optional HTTP/JSON front end to the same price model and cryptokeys as
+// the TCP line protocol:
+//
+//	GET /quote?sym=&user=   a quote, as JSON
+//	GET /health             "ok"
+//	GET /stats              connection and request counters
+//	GET /market?sym=        whether sym is trading, or every halted symbol
+//	POST /halt?sym=         halts trading in sym
+//	POST /resume?sym=       resumes trading in sym
+//
+// Halting and resuming need "Authorization: Bearer $QUOTE_ADMIN_TOKEN".
+
+type httpQuote struct {
+	Price     float64 `json:"price"`
+	Sym       string  `json:"sym"`
+	User      string  `json:"user"`
+	Timestamp int64   `json:"timestamp"`
+	Cryptokey string  `json:"cryptokey"`
+}
+
+// Starts serving in the background. The caller shuts the returned server down.
+func startHTTP(bind string, quoter *client) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/quote", func(w http.ResponseWriter, r *http.Request) {
+		handleHTTPQuote(w, r, quoter)
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, stats.snapshot())
+	})
+	mux.HandleFunc("/market", handleMarket)
+	mux.HandleFunc("/halt", func(w http.ResponseWriter, r *http.Request) {
+		handleHalt(w, r, halt)
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		handleHalt(w, r, resume)
+	})
+
+	server := &http.Server{Addr: bind, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalln(err)
+		}
+	}()
+	return server
+}
+
+func handleHTTPQuote(w http.ResponseWriter, r *http.Request, quoter *client) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, "GET only")
+		return
+	}
+
+	sym := r.URL.Query().Get("sym")
+	user := r.URL.Query().Get("user")
+	// Same restrictions as the line protocol, so both produce the same quotes
+	if sym == "" || user == "" || strings.ContainsAny(sym, " ,\r\n") || strings.ContainsAny(user, ",\r\n") {
+		writeJSON(w, http.StatusBadRequest, "sym and user are required and may not contain commas or newlines")
+		return
+	}
+
+	stats.request(sym)
+
+	switch status := marketStatus(sym); status {
+	case MARKET_UNKNOWN:
+		writeJSON(w, http.StatusNotFound, status)
+		return
+	case MARKET_CLOSED, MARKET_HALTED:
+		writeJSON(w, http.StatusConflict, status)
+		return
+	}
+
+	priceInCents, err := quotePrice(sym)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	line, err := quoter.quoteLine(sym, user, priceInCents)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	fields := strings.Split(strings.TrimSuffix(line, "\n"), ",")
+	timestamp, _ := strconv.ParseInt(fields[3], 10, 64)
+
+	writeJSON(w, http.StatusOK, httpQuote{
+		Price:     float64(priceInCents) / 100,
+		Sym:       sym,
+		User:      user,
+		Timestamp: timestamp,
+		Cryptokey: fields[4],
+	})
+}
+
+type marketInfo struct {
+	Sym    string `json:"sym"`
+	Status string `json:"status"`
+}
+
+func handleMarket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, "GET only")
+		return
+	}
+
+	sym := r.URL.Query().Get("sym")
+	if sym == "" {
+		writeJSON(w, http.StatusOK, map[string][]string{"halted": haltedSymbols()})
+		return
+	}
+	writeJSON(w, http.StatusOK, marketInfo{Sym: sym, Status: marketStatus(sym)})
+}
+
+// Halts or resumes the symbol named in the request
+func handleHalt(w http.ResponseWriter, r *http.Request, apply func(sym string)) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, "POST only")
+		return
+	}
+	if adminToken == "" {
+		writeJSON(w, http.StatusForbidden, "halts are off, set QUOTE_ADMIN_TOKEN to allow them")
+		return
+	}
+	if !isAdmin(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, "admin token required")
+		return
+	}
+
+	sym := r.URL.Query().Get("sym")
+	if sym == "" {
+		writeJSON(w, http.StatusBadRequest, "sym is required")
+		return
+	}
+	if !known(sym) {
+		writeJSON(w, http.StatusNotFound, MARKET_UNKNOWN)
+		return
+	}
+
+	apply(sym)
+	log.Printf("%s %s\n", strings.TrimPrefix(r.URL.Path, "/"), sym)
+	writeJSON(w, http.StatusOK, marketInfo{Sym: sym, Status: marketStatus(sym)})
+}
+
+// Whether the request carries the admin token
+func isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Sets QUOTE_ADMIN_TOKEN for the length of the test, with nothing halted
+func withAdminToken(t *testing.T, token string) {
+	t.Helper()
+
+	oldToken, oldUniverse, oldHalted := adminToken, universe, halted
+	t.Cleanup(func() { adminToken, universe, halted = oldToken, oldUniverse, oldHalted })
+	adminToken, universe, halted = token, nil, map[string]bool{}
+}
+
+func postHalt(authorization string) int {
+	r := httptest.NewRequest(http.MethodPost, "/halt?sym=ABC", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	handleHalt(w, r, halt)
+	return w.Code
+}
+
+func TestHaltNeedsTheAdminToken(t *testing.T) {
+	withAdminToken(t, "secret")
+
+	for _, authorization := range []string{"", "secret", "Bearer", "Bearer wrong", "Bearer secretsecret", "Basic secret"} {
+		if code := postHalt(authorization); code != http.StatusUnauthorized {
+			t.Errorf("%q: got %d, want 401", authorization, code)
+		}
+	}
+	if status := marketStatus("ABC"); status == MARKET_HALTED {
+		t.Fatal("halted without the admin token")
+	}
+
+	if code := postHalt("Bearer secret"); code != http.StatusOK {
+		t.Fatalf("got %d, want 200", code)
+	}
+	if status := marketStatus("ABC"); status != MARKET_HALTED {
+		t.Errorf("got %s, want %s", status, MARKET_HALTED)
+	}
+}
+
+func TestHaltOffWithoutAdminToken(t *testing.T) {
+	withAdminToken(t, "")
+
+	for _, authorization := range []string{"", "Bearer "} {
+		if code := postHalt(authorization); code != http.StatusForbidden {
+			t.Errorf("%q: got %d, want 403", authorization, code)
+		}
+	}
+	if status := marketStatus("ABC"); status == MARKET_HALTED {
+		t.Error("halted with halts turned off")
+	}
+}
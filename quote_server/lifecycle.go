@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits on connections and how the server shuts down. Each connection takes
+// one of --max-conns slots; connections beyond that are closed straight away.
+// A client that sends nothing for --idle-timeout is disconnected, unless it is
+// subscribed and waiting on pushed updates. On SIGTERM the listener is closed
+// and every connection gets a read deadline of now, so lines already received
+// are still answered before the connection ends.
+
+// Set by the connection flags
+var maxConns int
+var idleTimeout time.Duration
+var writeTimeout time.Duration
+var drainTimeout time.Duration
+
+var shuttingDown atomic.Bool
+
+var clients = map[*client]struct{}{}
+var clientsMu sync.Mutex
+var clientsWg sync.WaitGroup
+
+// Accepts connections until the listener is closed
+func acceptLoop(ln net.Listener) {
+	slots := make(chan struct{}, maxConns)
+	backoff := 5 * time.Millisecond
+
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// e.g. running out of file descriptors; wait for some to free up
+			log.Printf("Error accepting connection: %s, retrying in %s\n", err, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > time.Second {
+				backoff = time.Second
+			}
+			continue
+		}
+		backoff = 5 * time.Millisecond
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			log.Println("Too many connections, refusing client")
+			conn.Close()
+			continue
+		}
+
+		log.Println("Client connected")
+		clientsWg.Add(1)
+		go func() {
+			defer clientsWg.Done()
+			defer func() { <-slots }()
+			interact(conn, atomic.AddUint64(&connCount, 1))
+		}()
+	}
+}
+
+func register(c *client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	clients[c] = struct{}{}
+}
+
+func unregister(c *client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	delete(clients, c)
+}
+
+// Sets the deadline for the client's next line
+func (c *client) armReadDeadline() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case shuttingDown.Load():
+		c.conn.SetReadDeadline(time.Now())
+	case idleTimeout == 0 || c.subscribed():
+		c.conn.SetReadDeadline(time.Time{})
+	default:
+		c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	}
+}
+
+// Lets every connection finish the lines it has received, waiting at most
+// --drain-timeout before cutting the rest off
+func drain() {
+	shuttingDown.Store(true)
+
+	clientsMu.Lock()
+	for c := range clients {
+		c.mu.Lock()
+		c.conn.SetReadDeadline(time.Now())
+		c.mu.Unlock()
+	}
+	clientsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		clientsWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("All clients drained")
+	case <-time.After(drainTimeout):
+		log.Println("Drain timed out, closing remaining clients")
+		clientsMu.Lock()
+		for c := range clients {
+			c.conn.Close()
+		}
+		clientsMu.Unlock()
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata"
+)
+
+// The market the server quotes for. --symbols and --symbols-file limit the
+// symbols it knows. With --market-hours set, symbols only trade between the
+// opening and closing time on --market-days, and any symbol can be halted and
+// resumed through the HTTP front end. A quote request for a symbol that is not
+// trading is answered with an error line instead of a price:
+//
+//	ERROR,<sym>,<user>,<status>
+//
+// where status is UNKNOWN, CLOSED or HALTED. The status can also be asked for
+// on its own with
+//
+//	STATUS <sym>
+//
+// which is answered with STATUS,<sym>,<status>, status being OPEN when the
+// symbol is trading.
+
+const (
+	STATUS = "STATUS"
+	ERROR  = "ERROR"
+
+	MARKET_OPEN    = "OPEN"
+	MARKET_CLOSED  = "CLOSED"
+	MARKET_HALTED  = "HALTED"
+	MARKET_UNKNOWN = "UNKNOWN"
+)
+
+// Trading hours, as offsets from midnight in loc
+type tradingCalendar struct {
+	open  time.Duration
+	close time.Duration
+	days  map[time.Weekday]bool
+	loc   *time.Location
+}
+
+// Set by --symbols and --symbols-file. Any symbol is known when nil.
+var universe map[string]bool
+
+// Set by --market-hours. The market never closes when nil.
+var calendar *tradingCalendar
+
+var halted = map[string]bool{}
+var haltedMu sync.Mutex
+
+// Builds the symbol universe from a comma separated list and a file with one
+// symbol per line. Leaves every symbol known if both are empty.
+func loadUniverse(list string, path string) error {
+	var syms []string
+	if list != "" {
+		syms = strings.Split(list, ",")
+	}
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			syms = append(syms, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	if list == "" && path == "" {
+		return nil
+	}
+
+	universe = map[string]bool{}
+	for _, sym := range syms {
+		sym = strings.TrimSpace(sym)
+		if sym == "" {
+			continue
+		}
+		if strings.ContainsAny(sym, " ,") {
+			return fmt.Errorf("bad symbol %q", sym)
+		}
+		universe[sym] = true
+	}
+	if len(universe) == 0 {
+		return fmt.Errorf("symbol universe is empty")
+	}
+	return nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Parses trading hours of the form HH:MM-HH:MM, the comma separated days they
+// apply on (mon,tue,...) and the time zone they are in
+func parseCalendar(hours string, days string, tz string) (*tradingCalendar, error) {
+	openSpec, closeSpec, found := strings.Cut(hours, "-")
+	if !found {
+		return nil, fmt.Errorf("bad market hours %q", hours)
+	}
+	open, err := parseClock(openSpec)
+	if err != nil {
+		return nil, err
+	}
+	close, err := parseClock(closeSpec)
+	if err != nil {
+		return nil, err
+	}
+	if close <= open {
+		return nil, fmt.Errorf("market must close after it opens: %q", hours)
+	}
+
+	cal := &tradingCalendar{open: open, close: close, days: map[time.Weekday]bool{}}
+	for _, d := range strings.Split(days, ",") {
+		day, found := weekdays[strings.ToLower(strings.TrimSpace(d))]
+		if !found {
+			return nil, fmt.Errorf("bad market day %q", d)
+		}
+		cal.days[day] = true
+	}
+
+	cal.loc, err = time.LoadLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+	return cal, nil
+}
+
+func parseClock(spec string) (time.Duration, error) {
+	t, err := time.Parse("15:04", spec)
+	if err != nil {
+		return 0, fmt.Errorf("bad time of day %q", spec)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (cal *tradingCalendar) isOpen(t time.Time) bool {
+	t = t.In(cal.loc)
+	if !cal.days[t.Weekday()] {
+		return false
+	}
+
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, cal.loc)
+	sinceMidnight := t.Sub(midnight)
+	return sinceMidnight >= cal.open && sinceMidnight < cal.close
+}
+
+func known(sym string) bool {
+	return universe == nil || universe[sym]
+}
+
+// Whether sym can be quoted right now, and why not
+func marketStatus(sym string) string {
+	if !known(sym) {
+		return MARKET_UNKNOWN
+	}
+	if calendar != nil && !calendar.isOpen(time.Now()) {
+		return MARKET_CLOSED
+	}
+
+	haltedMu.Lock()
+	defer haltedMu.Unlock()
+
+	if halted[sym] {
+		return MARKET_HALTED
+	}
+	return MARKET_OPEN
+}
+
+func halt(sym string) {
+	haltedMu.Lock()
+	defer haltedMu.Unlock()
+	halted[sym] = true
+}
+
+func resume(sym string) {
+	haltedMu.Lock()
+	defer haltedMu.Unlock()
+	delete(halted, sym)
+}
+
+func haltedSymbols() []string {
+	haltedMu.Lock()
+	defer haltedMu.Unlock()
+
+	syms := make([]string, 0, len(halted))
+	for sym := range halted {
+		syms = append(syms, sym)
+	}
+	sort.Strings(syms)
+	return syms
+}
+
+func statusLine(sym string) string {
+	return STATUS + "," + sym + "," + marketStatus(sym) + "\n"
+}
+
+func errorLine(sym string, username string, status string) string {
+	return ERROR + "," + sym + "," + username + "," + status + "\n"
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// Every symbol follows its own geometric Brownian motion, shared by all
+// connections. Each quote request for a symbol advances its price by one step:
+//
+//	S' = S * exp((drift - volatility^2/2) + volatility*Z),  Z ~ N(0, 1)
+//
+// Steps are counted in requests rather than wall-clock time so a seeded run
+// produces the same path no matter how fast it is driven.
+
+// Set by --drift and --volatility, per step
+var drift float64
+var volatility float64
+
+const MIN_PRICE_IN_CENTS = 1
+
+type symbolPrice struct {
+	mu    sync.Mutex
+	price float64 // dollars
+	gen   *generator
+}
+
+var symbolPrices = map[string]*symbolPrice{}
+var symbolPricesMu sync.Mutex
+
+// Derives a starting price between $1.00 and $300.00 from the symbol itself,
+// so a symbol always opens at the same price
+func initialPrice(sym string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(sym))
+	return float64(100+h.Sum64()%29901) / 100
+}
+
+func priceFor(sym string) (*symbolPrice, error) {
+	symbolPricesMu.Lock()
+	defer symbolPricesMu.Unlock()
+
+	p, found := symbolPrices[sym]
+	if found {
+		return p, nil
+	}
+
+	var gen *generator
+	if seeded {
+		gen = newSeededGenerator(seed, "sym:"+sym)
+	} else {
+		var err error
+		gen, err = newRandomGenerator()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p = &symbolPrice{price: initialPrice(sym), gen: gen}
+	symbolPrices[sym] = p
+	return p, nil
+}
+
+// Advances the symbol's price one step and returns it in cents
+func nextPrice(sym string) (int, error) {
+	p, err := priceFor(sym)
+	if err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	z := p.gen.normal()
+	p.price *= math.Exp(drift - volatility*volatility/2 + volatility*z)
+
+	cents := int(math.Round(p.price * 100))
+	if cents < MIN_PRICE_IN_CENTS {
+		cents = MIN_PRICE_IN_CENTS
+		p.price = float64(cents) / 100
+	}
+	return cents, nil
+}
+
+// The generator handing out the symbol's cryptokeys in --seed-per-symbol-keys mode
+func symbolGenerator(sym string) (*generator, error) {
+	p, err := priceFor(sym)
+	if err != nil {
+		return nil, err
+	}
+	return p.gen, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Sets --drift and --volatility for the length of the test
+func withModel(t *testing.T, d float64, v float64) {
+	t.Helper()
+
+	oldDrift, oldVolatility := drift, volatility
+	t.Cleanup(func() { drift, volatility = oldDrift, oldVolatility })
+	drift, volatility = d, v
+}
+
+func TestInitialPrice(t *testing.T) {
+	for _, sym := range []string{"A", "ABC", "XYZ", "GOOG", "ZZZZZ"} {
+		p := initialPrice(sym)
+		if p < 1 || p > 300 {
+			t.Errorf("%s opens at %.2f, outside $1.00-$300.00", sym, p)
+		}
+		if again := initialPrice(sym); again != p {
+			t.Errorf("%s opens at %.2f, then %.2f", sym, p, again)
+		}
+	}
+}
+
+func TestNextPriceFollowsDrift(t *testing.T) {
+	withModel(t, 0.01, 0)
+
+	withSeed(t, 1, func() {
+		open := initialPrice("ABC")
+		for step := 1; step <= 10; step++ {
+			cents, err := nextPrice("ABC")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := int(math.Round(open * math.Exp(0.01*float64(step)) * 100))
+			if cents != want {
+				t.Fatalf("step %d: got %d cents, want %d", step, cents, want)
+			}
+		}
+	})
+}
+
+func TestNextPriceFloor(t *testing.T) {
+	withModel(t, -10, 0)
+
+	withSeed(t, 1, func() {
+		for step := 0; step < 3; step++ {
+			cents, err := nextPrice("ABC")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cents != MIN_PRICE_IN_CENTS {
+				t.Fatalf("step %d: got %d cents, want the %d cent floor", step, cents, MIN_PRICE_IN_CENTS)
+			}
+		}
+	})
+}
+
+func TestSymbolsMoveIndependently(t *testing.T) {
+	withModel(t, 0, 0.05)
+
+	path := func(interleave bool) []int {
+		var prices []int
+		withSeed(t, 3, func() {
+			for i := 0; i < 10; i++ {
+				if interleave {
+					if _, err := nextPrice("XYZ"); err != nil {
+						t.Fatal(err)
+					}
+				}
+				cents, err := nextPrice("ABC")
+				if err != nil {
+					t.Fatal(err)
+				}
+				prices = append(prices, cents)
+			}
+		})
+		return prices
+	}
+
+	if alone, interleaved := path(false), path(true); !reflect.DeepEqual(alone, interleaved) {
+		t.Errorf("quoting XYZ changed ABC's path:\n%v\n%v", alone, interleaved)
+	}
+}
+
+func TestLogReturnsMatchTheModel(t *testing.T) {
+	const steps = 5000
+	withModel(t, 0.001, 0.02)
+
+	withSeed(t, 5, func() {
+		p, err := priceFor("ABC")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sum, sumSq float64
+		prev := p.price
+		for i := 0; i < steps; i++ {
+			if _, err := nextPrice("ABC"); err != nil {
+				t.Fatal(err)
+			}
+			r := math.Log(p.price / prev)
+			sum += r
+			sumSq += r * r
+			prev = p.price
+		}
+
+		mean := sum / steps
+		stddev := math.Sqrt(sumSq/steps - mean*mean)
+		// Mean log return is drift - volatility^2/2, with a standard error of
+		// volatility/sqrt(steps)
+		if want := 0.001 - 0.02*0.02/2; math.Abs(mean-want) > 4*0.02/math.Sqrt(steps) {
+			t.Errorf("mean log return %g, want about %g", mean, want)
+		}
+		if math.Abs(stddev-0.02) > 0.002 {
+			t.Errorf("log return stddev %g, want about 0.02", stddev)
+		}
+	})
+}
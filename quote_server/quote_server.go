@@ -3,74 +3,262 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"crypto/aes"
-	cryptoRand "crypto/rand"
-	"encoding/base64"
-	"encoding/binary"
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	mathRand "math/rand"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"quotesig"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// Set by --seed. When seeded, every generator is derived from the seed so
+// price sequences repeat from run to run, and so do cryptokeys unless
+// QUOTE_SIGNING_KEY is set: a signature covers the quote's wall-clock
+// timestamp.
+var seeded bool
+var seed int64
+
+// Set by --seed-per-symbol-keys. Cryptokeys then come from the symbol's own
+// generator, shared by all connections, instead of the connection's, so they
+// do not depend on which connection asks or how requests for other symbols
+// interleave with it. Prices are always per symbol.
+var perSymbolKeys bool
+
+var connCount uint64
+
+// Loaded from QUOTE_SIGNING_KEY. When set, the cryptokey of every quote is its
+// Ed25519 signature rather than random bytes.
+var signingKey ed25519.PrivateKey
+
+// Loaded from QUOTE_ADMIN_TOKEN. POST /halt and /resume need it as a bearer
+// token, and are turned off when it isn't set.
+var adminToken string
+
 func main() {
 	bind := flag.String("bind", "localhost:4444", "host:port to listen on")
+	httpBind := flag.String("http", "", "host:port to serve the HTTP/JSON front end on, off if empty")
+	flag.Int64Var(&seed, "seed", 0, "seed making prices, and cryptokeys unless quotes are signed, deterministic")
+	flag.BoolVar(&perSymbolKeys, "seed-per-symbol-keys", false, "with --seed, derive cryptokeys per symbol rather than per connection")
+	flag.Float64Var(&drift, "drift", 0, "price drift per quote")
+	flag.Float64Var(&volatility, "volatility", 0.01, "price volatility per quote")
+	scenarioFile := flag.String("scenario", "", "CSV or JSON file of scripted prices to play back")
+	scenarioBy := flag.String("scenario-by", SCENARIO_BY_INDEX, "what scenario rows are keyed by: index or time")
+	scenarioEnd := flag.String("scenario-end", SCENARIO_HOLD, "what to do past the end of a scenario: loop, hold or error")
+	pushInterval := flag.Duration("push-interval", time.Second, "interval between price updates pushed to subscribers")
+	latency := flag.String("latency", "none", "reply latency: none, fixed:D, uniform:MIN-MAX, normal:MEAN,STDDEV or exp:MEAN")
+	flag.Float64Var(&faults.dropRate, "drop-rate", 0, "probability of dropping the connection instead of replying")
+	flag.Float64Var(&faults.malformedRate, "malformed-rate", 0, "probability of replying with a malformed line")
+	flag.Float64Var(&faults.stallRate, "stall-rate", 0, "probability of stalling before replying")
+	flag.DurationVar(&faults.stallDuration, "stall-duration", time.Minute, "how long a stalled reply is held back")
+	symbols := flag.String("symbols", "", "comma separated symbols to quote, any symbol if empty")
+	symbolsFile := flag.String("symbols-file", "", "file of symbols to quote, one per line")
+	marketHours := flag.String("market-hours", "", "trading hours as HH:MM-HH:MM, always open if empty")
+	marketDays := flag.String("market-days", "mon,tue,wed,thu,fri", "days the market opens on")
+	marketTz := flag.String("market-tz", "America/New_York", "time zone of the market hours")
+	flag.IntVar(&maxConns, "max-conns", 1024, "maximum concurrent TCP connections")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 5*time.Minute, "disconnect clients idle this long, 0 to never")
+	flag.DurationVar(&writeTimeout, "write-timeout", 10*time.Second, "deadline for writing a line to a client, 0 for none")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "how long to wait for clients to finish on shutdown")
+	genKey := flag.Bool("gen-key", false, "print a new QUOTE_SIGNING_KEY and matching QUOTE_VERIFY_KEY and exit")
 	flag.Parse()
 
-	ln, err := net.Listen("tcp", *bind)
+	if *genKey {
+		priv, pub, err := quotesig.GenerateKeys()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("QUOTE_SIGNING_KEY=%s\nQUOTE_VERIFY_KEY=%s\n", priv, pub)
+		return
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			seeded = true
+		}
+	})
+
+	var err error
+	if key, found := os.LookupEnv("QUOTE_SIGNING_KEY"); found {
+		signingKey, err = quotesig.ParsePrivateKey(key)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	adminToken = os.Getenv("QUOTE_ADMIN_TOKEN")
+
+	faults.latency, err = parseLatency(*latency)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	if err := faults.validate(); err != nil {
+		log.Fatalln(err)
+	}
+	if seeded {
+		setupFaults(seed)
+	} else {
+		setupFaults(time.Now().UnixNano())
+	}
+
+	if err := loadUniverse(*symbols, *symbolsFile); err != nil {
+		log.Fatalln(err)
+	}
+	if *marketHours != "" {
+		calendar, err = parseCalendar(*marketHours, *marketDays, *marketTz)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 
-	for {
-		conn, err := ln.Accept()
+	if *scenarioFile != "" {
+		activeScenario, err = loadScenario(*scenarioFile, *scenarioBy, *scenarioEnd)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	go pushUpdates(*pushInterval)
+
+	var httpServer *http.Server
+	if *httpBind != "" {
+		// HTTP quotes get their own generator, as if they were one more connection
+		gen, err := connGenerator(atomic.AddUint64(&connCount, 1))
 		if err != nil {
 			log.Fatalln(err)
 		}
+		httpServer = startHTTP(*httpBind, &client{gen: gen})
+	}
+
+	ln, err := net.Listen("tcp", *bind)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down")
+		ln.Close()
+	}()
+
+	acceptLoop(ln)
 
-		log.Println("Client connected")
-		go interact(conn)
+	if httpServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
 	}
+	drain()
 }
 
-func interact(conn net.Conn) {
+// Returns the generator for the nth connection
+func connGenerator(n uint64) (*generator, error) {
+	if seeded {
+		return newSeededGenerator(seed, "conn:"+strconv.FormatUint(n, 10)), nil
+	}
+	return newRandomGenerator()
+}
+
+// A connected client. Pushed updates and replies can be written from
+// different goroutines, so writes are serialized.
+type client struct {
+	conn net.Conn
+	gen  *generator
+
+	mu sync.Mutex
+
+	// Number of symbols subscribed to, guarded by subscriptionsMu
+	subs int
+}
+
+func (c *client) write(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+	_, err := c.conn.Write([]byte(line))
+	return err
+}
+
+// Formats a quote line price,sym,user,timestamp,key for the client
+func (c *client) quoteLine(sym string, username string, priceInCents int) (string, error) {
+	timestamp := time.Now().UnixMilli()
+
+	var responseKey string
+	if signingKey != nil {
+		responseKey = quotesig.Sign(signingKey, sym, int64(priceInCents), timestamp)
+	} else {
+		g := c.gen
+		if seeded && perSymbolKeys {
+			var err error
+			g, err = symbolGenerator(sym)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		var err error
+		responseKey, err = g.key()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%d.%02d,%s,%s,%d,%s\n", priceInCents/100, priceInCents%100, sym, username, timestamp, responseKey), nil
+}
+
+func interact(conn net.Conn, n uint64) {
 	defer conn.Close()
 
-	rngSeed := make([]byte, 16)
-	if _, err := cryptoRand.Read(rngSeed); err != nil {
+	gen, err := connGenerator(n)
+	if err != nil {
 		log.Printf("Error generating random seed: %s\n", err)
 		return
 	}
 
-	rng, err := aes.NewCipher(rngSeed)
-	if err != nil {
-		// this should never happen
-		panic(err)
-	}
+	c := &client{conn: conn, gen: gen}
+	defer unsubscribeAll(c)
 
-	counter := uint32(0)
-	counterBuf := make([]byte, 16)
+	stats.connected()
+	defer stats.disconnected()
 
-	weakRngSeed := make([]byte, 16)
+	register(c)
+	defer unregister(c)
 
-	rng.Encrypt(weakRngSeed, counterBuf)
-	counter += 1
-	binary.LittleEndian.PutUint32(counterBuf[:4], counter)
+	scanner := bufio.NewScanner(conn)
 
-	weakRng := mathRand.New(mathRand.NewSource(int64(binary.LittleEndian.Uint64(weakRngSeed[:8]))))
+	for {
+		c.armReadDeadline()
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Bytes()
 
-	scanner := bufio.NewScanner(conn)
-	responseKeyBuf := make([]byte, 32)
+		if bytes.HasPrefix(line, []byte(SUBSCRIBE+" ")) || bytes.HasPrefix(line, []byte(UNSUBSCRIBE+" ")) {
+			if err := handleSubscription(c, string(line)); err != nil {
+				log.Printf("Error parsing client request: %s\n", err)
+				return
+			}
+			continue
+		}
 
-	for scanner.Scan() {
-		if counter >= 0xffffff00 {
-			log.Println("Counter overflow")
-			return
+		if sym, found := bytes.CutPrefix(line, []byte(STATUS+" ")); found {
+			c.write(statusLine(string(sym)))
+			continue
 		}
 
-		line := scanner.Bytes()
 		words := bytes.SplitN(line, []byte(" "), 2)
 
 		if len(words) != 2 {
@@ -81,25 +269,51 @@ func interact(conn net.Conn) {
 		sym := words[0]
 		username := words[1]
 
-		priceInCents := weakRng.Intn(30000 + 1)
+		stats.request(string(sym))
 
-		rng.Encrypt(responseKeyBuf[:16], counterBuf)
-		counter += 1
-		binary.LittleEndian.PutUint32(counterBuf[:4], counter)
+		if status := marketStatus(string(sym)); status != MARKET_OPEN {
+			c.write(errorLine(string(sym), string(username), status))
+			continue
+		}
 
-		rng.Encrypt(responseKeyBuf[16:], counterBuf)
-		counter += 1
-		binary.LittleEndian.PutUint32(counterBuf[:4], counter)
+		priceInCents, err := quotePrice(string(sym))
+		if err == errScenarioEnded {
+			c.write(errorLine(string(sym), string(username), MARKET_CLOSED))
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			return
+		}
 
-		responseKey := base64.RawURLEncoding.EncodeToString(responseKeyBuf[:20])
-		timestamp := time.Now().UnixMilli()
+		response, err := c.quoteLine(string(sym), string(username), priceInCents)
+		if err != nil {
+			log.Println(err)
+			return
+		}
 
-		response := fmt.Sprintf("%d.%02d,%s,%s,%d,%s\n", priceInCents/100, priceInCents%100, sym, username, timestamp, responseKey)
+		time.Sleep(faults.latency.sample())
+		switch faults.draw() {
+		case FAULT_DROP:
+			log.Println("Dropping client")
+			return
+		case FAULT_MALFORMED:
+			response = malform(response)
+		case FAULT_STALL:
+			time.Sleep(faults.stallDuration)
+		}
 
-		conn.Write([]byte(response))
+		c.write(response)
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := scanner.Err(); errors.Is(err, os.ErrDeadlineExceeded) {
+		if shuttingDown.Load() {
+			log.Println("Client drained")
+		} else {
+			log.Println("Client idle, disconnecting")
+		}
+		return
+	} else if err != nil {
 		log.Printf("Error reading line from client: %s\n", err)
 		return
 	}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A scenario replays fixed price paths instead of the price model. It is
+// loaded from a CSV file with the header symbol,at,price or a JSON file of the
+// form {"SYM": [{"at": 0, "price": 12.34}, ...]}. Depending on --scenario-by,
+// "at" is either the index of the request for that symbol (0 for the first
+// quote) or the number of seconds since the server started. A symbol is quoted
+// at the price of the last row at or before its current position. Symbols that
+// are not in the scenario keep following the price model. Past the end of a
+// path in error mode, quote requests for the symbol are answered as if its
+// market had closed, with ERROR,<sym>,<user>,CLOSED.
+
+const (
+	SCENARIO_BY_INDEX = "index"
+	SCENARIO_BY_TIME  = "time"
+
+	SCENARIO_LOOP  = "loop"
+	SCENARIO_HOLD  = "hold"
+	SCENARIO_ERROR = "error"
+)
+
+var errScenarioEnded = errors.New("Scenario ended")
+
+type scenarioPoint struct {
+	At    float64 `json:"at"`
+	Price float64 `json:"price"`
+}
+
+type scenario struct {
+	by      string
+	end     string
+	started time.Time
+	paths   map[string][]scenarioPoint
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+// Set by --scenario
+var activeScenario *scenario
+
+func loadScenario(path string, by string, end string) (*scenario, error) {
+	if by != SCENARIO_BY_INDEX && by != SCENARIO_BY_TIME {
+		return nil, fmt.Errorf("unknown scenario key %q", by)
+	}
+	if end != SCENARIO_LOOP && end != SCENARIO_HOLD && end != SCENARIO_ERROR {
+		return nil, fmt.Errorf("unknown scenario end %q", end)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths map[string][]scenarioPoint
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.NewDecoder(f).Decode(&paths)
+	case ".csv":
+		paths, err = readScenarioCSV(f)
+	default:
+		err = fmt.Errorf("scenario must be a .csv or .json file")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for sym, points := range paths {
+		if len(points) == 0 {
+			return nil, fmt.Errorf("scenario has no prices for %s", sym)
+		}
+		for _, p := range points {
+			if p.At < 0 || p.Price < 0 {
+				return nil, fmt.Errorf("scenario has a negative value for %s", sym)
+			}
+			if math.IsNaN(p.At+p.Price) || math.IsInf(p.At+p.Price, 0) {
+				return nil, fmt.Errorf("scenario has a value for %s that isn't a number", sym)
+			}
+		}
+		sort.SliceStable(points, func(i, j int) bool { return points[i].At < points[j].At })
+	}
+
+	return &scenario{by: by, end: end, started: time.Now(), paths: paths, requests: map[string]int{}}, nil
+}
+
+func readScenarioCSV(r io.Reader) (map[string][]scenarioPoint, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || strings.Join(records[0], ",") != "symbol,at,price" {
+		return nil, errors.New("scenario CSV must start with the header symbol,at,price")
+	}
+
+	paths := map[string][]scenarioPoint{}
+	for i, rec := range records[1:] {
+		at, err := strconv.ParseFloat(rec[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("scenario line %d: %s", i+2, err)
+		}
+		price, err := strconv.ParseFloat(rec[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("scenario line %d: %s", i+2, err)
+		}
+		paths[rec[0]] = append(paths[rec[0]], scenarioPoint{At: at, Price: price})
+	}
+	return paths, nil
+}
+
+// Returns the scripted price for sym in cents. found is false for symbols
+// the scenario does not cover.
+func (s *scenario) price(sym string) (cents int, found bool, err error) {
+	points, found := s.paths[sym]
+	if !found {
+		return 0, false, nil
+	}
+
+	var pos float64
+	if s.by == SCENARIO_BY_INDEX {
+		s.mu.Lock()
+		pos = float64(s.requests[sym])
+		s.requests[sym]++
+		s.mu.Unlock()
+	} else {
+		pos = time.Since(s.started).Seconds()
+	}
+
+	last := points[len(points)-1].At
+	if pos > last {
+		switch s.end {
+		case SCENARIO_ERROR:
+			return 0, true, errScenarioEnded
+		case SCENARIO_LOOP:
+			// By index the path repeats after its last request; by time the
+			// last row marks the end of the period
+			if s.by == SCENARIO_BY_INDEX {
+				pos = math.Mod(pos, last+1)
+			} else if last > 0 {
+				pos = math.Mod(pos, last)
+			}
+		}
+	}
+
+	// Last row at or before pos, or the first row before the path begins
+	i := sort.Search(len(points), func(i int) bool { return points[i].At > pos })
+	if i > 0 {
+		i--
+	}
+
+	return int(math.Round(points[i].Price * 100)), true, nil
+}
+
+// The price to quote for sym, from the scenario if it covers sym and from
+// the price model otherwise
+func quotePrice(sym string) (int, error) {
+	if activeScenario != nil {
+		cents, found, err := activeScenario.price(sym)
+		if found {
+			return cents, err
+		}
+	}
+	return nextPrice(sym)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeScenario(t *testing.T, name string, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadScenarioCSV(t *testing.T) {
+	paths, err := readScenarioCSV(strings.NewReader("symbol,at,price\nABC,0,10\nABC,2,12.5\nXYZ,0,1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]scenarioPoint{
+		"ABC": {{At: 0, Price: 10}, {At: 2, Price: 12.5}},
+		"XYZ": {{At: 0, Price: 1}},
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got %+v, want %+v", paths, want)
+	}
+}
+
+func TestReadScenarioCSVRejects(t *testing.T) {
+	for name, csv := range map[string]string{
+		"empty":           "",
+		"wrong header":    "sym,at,price\nABC,0,10\n",
+		"bad at":          "symbol,at,price\nABC,soon,10\n",
+		"bad price":       "symbol,at,price\nABC,0,cheap\n",
+		"missing field":   "symbol,at,price\nABC,0\n",
+		"too many fields": "symbol,at,price\nABC,0,10,11\n",
+	} {
+		if paths, err := readScenarioCSV(strings.NewReader(csv)); err == nil {
+			t.Errorf("%s: got %+v, want an error", name, paths)
+		}
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	csvPath := writeScenario(t, "prices.csv", "symbol,at,price\nABC,2,12\nABC,0,10\n")
+	jsonPath := writeScenario(t, "prices.json", `{"ABC": [{"at": 2, "price": 12}, {"at": 0, "price": 10}]}`)
+
+	for _, path := range []string{csvPath, jsonPath} {
+		s, err := loadScenario(path, SCENARIO_BY_INDEX, SCENARIO_HOLD)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		// Rows are sorted by position whatever order the file has them in
+		want := []scenarioPoint{{At: 0, Price: 10}, {At: 2, Price: 12}}
+		if !reflect.DeepEqual(s.paths["ABC"], want) {
+			t.Errorf("%s: got %+v, want %+v", path, s.paths["ABC"], want)
+		}
+	}
+}
+
+func TestLoadScenarioRejects(t *testing.T) {
+	valid := writeScenario(t, "prices.csv", "symbol,at,price\nABC,0,10\n")
+
+	tests := []struct {
+		name string
+		path string
+		by   string
+		end  string
+	}{
+		{"unknown key", valid, "request", SCENARIO_HOLD},
+		{"unknown end", valid, SCENARIO_BY_INDEX, "stop"},
+		{"missing file", filepath.Join(t.TempDir(), "missing.csv"), SCENARIO_BY_INDEX, SCENARIO_HOLD},
+		{"unknown extension", writeScenario(t, "prices.txt", "symbol,at,price\nABC,0,10\n"), SCENARIO_BY_INDEX, SCENARIO_HOLD},
+		{"negative price", writeScenario(t, "negative.csv", "symbol,at,price\nABC,0,-10\n"), SCENARIO_BY_INDEX, SCENARIO_HOLD},
+		{"negative at", writeScenario(t, "early.csv", "symbol,at,price\nABC,-1,10\n"), SCENARIO_BY_INDEX, SCENARIO_HOLD},
+		{"NaN price", writeScenario(t, "nan.csv", "symbol,at,price\nABC,0,NaN\n"), SCENARIO_BY_INDEX, SCENARIO_HOLD},
+		{"infinite at", writeScenario(t, "inf.csv", "symbol,at,price\nABC,Inf,10\n"), SCENARIO_BY_INDEX, SCENARIO_HOLD},
+		{"empty path", writeScenario(t, "empty.json", `{"ABC": []}`), SCENARIO_BY_INDEX, SCENARIO_HOLD},
+		{"bad JSON", writeScenario(t, "bad.json", `{"ABC": [{"at": "0"}]}`), SCENARIO_BY_INDEX, SCENARIO_HOLD},
+	}
+	for _, tt := range tests {
+		if _, err := loadScenario(tt.path, tt.by, tt.end); err == nil {
+			t.Errorf("%s: loaded", tt.name)
+		}
+	}
+}
+
+// Prices for the first n requests for sym
+func playScenario(t *testing.T, s *scenario, sym string, n int) []int {
+	t.Helper()
+
+	var prices []int
+	for i := 0; i < n; i++ {
+		cents, found, err := s.price(sym)
+		if !found {
+			t.Fatalf("%s not in scenario", sym)
+		}
+		if err != nil {
+			prices = append(prices, -1)
+			continue
+		}
+		prices = append(prices, cents)
+	}
+	return prices
+}
+
+func TestScenarioPriceByIndex(t *testing.T) {
+	path := writeScenario(t, "prices.csv", "symbol,at,price\nABC,0,10\nABC,2,12.345\n")
+
+	tests := []struct {
+		end  string
+		want []int
+	}{
+		{SCENARIO_HOLD, []int{1000, 1000, 1235, 1235, 1235}},
+		{SCENARIO_LOOP, []int{1000, 1000, 1235, 1000, 1000, 1235}},
+		{SCENARIO_ERROR, []int{1000, 1000, 1235, -1}},
+	}
+	for _, tt := range tests {
+		s, err := loadScenario(path, SCENARIO_BY_INDEX, tt.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := playScenario(t, s, "ABC", len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestScenarioSkipsUncoveredSymbols(t *testing.T) {
+	s, err := loadScenario(writeScenario(t, "prices.csv", "symbol,at,price\nABC,0,10\n"), SCENARIO_BY_INDEX, SCENARIO_HOLD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := s.price("XYZ"); found {
+		t.Error("XYZ found in a scenario without it")
+	}
+}
+
+func TestEndedScenarioIsAnsweredWithAnError(t *testing.T) {
+	s, err := loadScenario(writeScenario(t, "prices.csv", "symbol,at,price\nABC,0,10\n"), SCENARIO_BY_INDEX, SCENARIO_ERROR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldScenario := activeScenario
+	defer func() { activeScenario = oldScenario }()
+	activeScenario = s
+	setupFaults(1)
+
+	conn, serverConn := net.Pipe()
+	defer conn.Close()
+	go interact(serverConn, 1)
+
+	replies := bufio.NewReader(conn)
+	ask := func() string {
+		t.Helper()
+		if _, err := fmt.Fprint(conn, "ABC alice\n"); err != nil {
+			t.Fatal(err)
+		}
+		line, err := replies.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return line
+	}
+
+	if line := ask(); !strings.HasPrefix(line, "10.00,ABC,alice,") {
+		t.Fatalf("first quote: got %q", line)
+	}
+	// The connection stays open past the end of the scenario
+	for i := 0; i < 2; i++ {
+		if line := ask(); line != "ERROR,ABC,alice,CLOSED\n" {
+			t.Fatalf("past the end: got %q", line)
+		}
+	}
+}
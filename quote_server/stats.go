@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Requests per second are averaged over this many whole seconds
+const STATS_WINDOW_SECS = 10
+
+type serverStats struct {
+	mu               sync.Mutex
+	started          time.Time
+	connections      int
+	totalConnections uint64
+	requests         uint64
+	perSymbol        map[string]uint64
+
+	// Requests per second for the last STATS_WINDOW_SECS seconds, indexed by
+	// unix second modulo the window
+	window     [STATS_WINDOW_SECS]uint64
+	windowSecs [STATS_WINDOW_SECS]int64
+}
+
+type statsSnapshot struct {
+	UptimeSecs        float64           `json:"uptimeSecs"`
+	Connections       int               `json:"connections"`
+	TotalConnections  uint64            `json:"totalConnections"`
+	Requests          uint64            `json:"requests"`
+	RequestsPerSecond float64           `json:"requestsPerSecond"`
+	PerSymbol         map[string]uint64 `json:"perSymbol"`
+}
+
+var stats = &serverStats{started: time.Now(), perSymbol: map[string]uint64{}}
+
+func (s *serverStats) connected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connections++
+	s.totalConnections++
+}
+
+func (s *serverStats) disconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connections--
+}
+
+// Counts one quote request for sym, over TCP or HTTP
+func (s *serverStats) request(sym string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	s.perSymbol[sym]++
+
+	now := time.Now().Unix()
+	i := now % STATS_WINDOW_SECS
+	if s.windowSecs[i] != now {
+		s.windowSecs[i] = now
+		s.window[i] = 0
+	}
+	s.window[i]++
+}
+
+func (s *serverStats) snapshot() statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only whole seconds count, so the current one is left out
+	now := time.Now().Unix()
+	var recent uint64
+	for i := range s.window {
+		if s.windowSecs[i] < now && s.windowSecs[i] >= now-STATS_WINDOW_SECS {
+			recent += s.window[i]
+		}
+	}
+
+	perSymbol := make(map[string]uint64, len(s.perSymbol))
+	for sym, n := range s.perSymbol {
+		perSymbol[sym] = n
+	}
+
+	return statsSnapshot{
+		UptimeSecs:        time.Since(s.started).Seconds(),
+		Connections:       s.connections,
+		TotalConnections:  s.totalConnections,
+		Requests:          s.requests,
+		RequestsPerSecond: float64(recent) / STATS_WINDOW_SECS,
+		PerSymbol:         perSymbol,
+	}
+}
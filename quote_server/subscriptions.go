@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Besides the request/response lines, clients can subscribe to symbols:
+//
+//	SUBSCRIBE <sym> <user>
+//	UNSUBSCRIBE <sym>
+//
+// Every --push-interval each subscribed symbol moves one step and an update
+// is pushed to its subscribers as "UPDATE " followed by a regular quote line,
+// so pushed prices can't be mistaken for replies to quote requests. There is
+// no reply to the commands themselves. Nothing is pushed for a symbol while it
+// is not trading.
+
+const (
+	SUBSCRIBE   = "SUBSCRIBE"
+	UNSUBSCRIBE = "UNSUBSCRIBE"
+	UPDATE      = "UPDATE"
+)
+
+// symbol -> subscribed client -> user the updates are for
+var subscriptions = map[string]map[*client]string{}
+var subscriptionsMu sync.Mutex
+
+func handleSubscription(c *client, line string) error {
+	fields := strings.Fields(line)
+
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+
+	switch {
+	case fields[0] == SUBSCRIBE && len(fields) == 3:
+		sym := fields[1]
+		if subscriptions[sym] == nil {
+			subscriptions[sym] = map[*client]string{}
+		}
+		if _, found := subscriptions[sym][c]; !found {
+			c.subs++
+		}
+		subscriptions[sym][c] = fields[2]
+	case fields[0] == UNSUBSCRIBE && len(fields) == 2:
+		sym := fields[1]
+		if _, found := subscriptions[sym][c]; found {
+			c.subs--
+		}
+		delete(subscriptions[sym], c)
+		if len(subscriptions[sym]) == 0 {
+			delete(subscriptions, sym)
+		}
+	default:
+		return fmt.Errorf("bad subscription request %q", line)
+	}
+	return nil
+}
+
+func unsubscribeAll(c *client) {
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+
+	c.subs = 0
+	for sym, subs := range subscriptions {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(subscriptions, sym)
+		}
+	}
+}
+
+func (c *client) subscribed() bool {
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+	return c.subs > 0
+}
+
+func pushUpdates(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Snapshot so slow clients don't hold up (un)subscribing
+		subscriptionsMu.Lock()
+		snapshot := make(map[string]map[*client]string, len(subscriptions))
+		for sym, subs := range subscriptions {
+			snapshot[sym] = make(map[*client]string, len(subs))
+			for c, user := range subs {
+				snapshot[sym][c] = user
+			}
+		}
+		subscriptionsMu.Unlock()
+
+		for sym, subs := range snapshot {
+			// Prices only move while the symbol trades
+			if marketStatus(sym) != MARKET_OPEN {
+				continue
+			}
+
+			priceInCents, err := quotePrice(sym)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			for c, user := range subs {
+				line, err := c.quoteLine(sym, user, priceInCents)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				if err := c.write(UPDATE + " " + line); err != nil {
+					// The connection's reader will notice and unsubscribe it
+					c.conn.Close()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module quoteclient
+
+go 1.20
@@ -0,0 +1,429 @@
+// Package quoteclient talks to the quote server over its newline delimited
+// line protocol. Requests share a small pool of long lived connections and are
+// pipelined on them: a request is written as soon as it is made and replies
+// are matched to requests in the order they were written.
+package quoteclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("quoteclient: connection closed")
+
+// Reasons a request or reply is rejected. Reply errors are wrapped in a
+// *ReplyError, so use errors.Is to tell them apart.
+var (
+	ErrInvalidRequest = errors.New("quoteclient: symbol and user must be non-empty and contain no spaces, commas or newlines")
+	ErrFieldCount     = errors.New("wrong number of fields")
+	ErrBadPrice       = errors.New("bad price")
+	ErrBadTimestamp   = errors.New("bad timestamp")
+	ErrSymbolMismatch = errors.New("symbol does not match request")
+	ErrUserMismatch   = errors.New("user does not match request")
+	ErrEmptyKey       = errors.New("empty cryptokey")
+	ErrBadStatus      = errors.New("bad market status")
+)
+
+// Whether a symbol is trading, as reported by Status and by error replies
+const (
+	MARKET_OPEN    = "OPEN"
+	MARKET_CLOSED  = "CLOSED"
+	MARKET_HALTED  = "HALTED"
+	MARKET_UNKNOWN = "UNKNOWN"
+)
+
+// Allowed difference between a quote's timestamp and the local clock
+var MaxClockSkew = 5 * time.Minute
+
+type ReplyError struct {
+	Line string
+	Err  error
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("quoteclient: bad reply %q: %s", e.Line, e.Err)
+}
+
+func (e *ReplyError) Unwrap() error {
+	return e.Err
+}
+
+// The quote server's answer to a quote request for a symbol that is not
+// trading. Status is one of MARKET_CLOSED, MARKET_HALTED or MARKET_UNKNOWN.
+type MarketError struct {
+	Sym    string
+	Status string
+}
+
+func (e *MarketError) Error() string {
+	return fmt.Sprintf("quoteclient: %s is not trading: %s", e.Sym, e.Status)
+}
+
+type Quote struct {
+	Price     float64
+	Sym       string
+	User      string
+	Timestamp int
+	Cryptokey string
+}
+
+type Client struct {
+	addr    string
+	timeout time.Duration
+	dial    func(ctx context.Context) (net.Conn, error)
+
+	mu     sync.Mutex
+	next   int
+	conns  []*conn
+	closed bool
+
+	// Closed when the slot's connection has been dialed, for slots being dialed
+	dialing []chan struct{}
+}
+
+// Creates a client keeping up to poolSize connections to addr. timeout bounds
+// every request whose context has no deadline of its own.
+func NewClient(addr string, poolSize int, timeout time.Duration) *Client {
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	c := &Client{addr: addr, timeout: timeout, conns: make([]*conn, poolSize), dialing: make([]chan struct{}, poolSize)}
+	c.dial = func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", c.addr)
+	}
+	return c
+}
+
+// Requests a quote for sym on behalf of user
+func (c *Client) Quote(ctx context.Context, sym string, user string) (Quote, error) {
+	if !validField(sym) || !validField(user) {
+		return Quote{}, ErrInvalidRequest
+	}
+
+	cn, line, err := c.request(ctx, sym+" "+user+"\n")
+	if err != nil {
+		return Quote{}, err
+	}
+
+	q, err := ParseReply(line, sym, user, time.Now())
+	var replyErr *ReplyError
+	if errors.As(err, &replyErr) {
+		// A reply for someone else means the connection is out of step
+		cn.fail(err)
+	}
+	if err != nil {
+		return Quote{}, err
+	}
+	return q, nil
+}
+
+// Asks whether sym is trading, without quoting it. Returns one of the MARKET_
+// statuses.
+func (c *Client) Status(ctx context.Context, sym string) (string, error) {
+	if !validField(sym) {
+		return "", ErrInvalidRequest
+	}
+
+	cn, line, err := c.request(ctx, "STATUS "+sym+"\n")
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Split(strings.TrimSuffix(line, "\n"), ",")
+	var replyErr error
+	switch {
+	case len(fields) != 3 || fields[0] != "STATUS":
+		replyErr = ErrFieldCount
+	case fields[1] != sym:
+		replyErr = ErrSymbolMismatch
+	case !validStatus(fields[2]):
+		replyErr = ErrBadStatus
+	}
+	if replyErr != nil {
+		err := &ReplyError{Line: line, Err: replyErr}
+		cn.fail(err)
+		return "", err
+	}
+	return fields[2], nil
+}
+
+// Sends one request line on a pooled connection and returns its reply along
+// with the connection, so a bad reply can poison it
+func (c *Client) request(ctx context.Context, request string) (*conn, string, error) {
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	cn, err := c.pick(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	line, err := cn.roundTrip(ctx, request)
+	if err != nil {
+		return nil, "", err
+	}
+	return cn, line, nil
+}
+
+// Closes every pooled connection
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for i, cn := range c.conns {
+		if cn != nil {
+			cn.fail(ErrClosed)
+			c.conns[i] = nil
+		}
+	}
+}
+
+// Picks the next connection round robin, redialing it if it has broken. The
+// slot is reserved while it is dialed so the dial happens outside c.mu; callers
+// landing on a slot someone else is dialing use any healthy connection instead,
+// and only wait for the dial if there is none.
+func (c *Client) pick(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	i := c.next
+	c.next = (c.next + 1) % len(c.conns)
+
+	for {
+		if c.closed {
+			c.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if cn := c.conns[i]; cn != nil && !cn.broken() {
+			c.mu.Unlock()
+			return cn, nil
+		}
+		if c.dialing[i] == nil {
+			break
+		}
+
+		for _, cn := range c.conns {
+			if cn != nil && !cn.broken() {
+				c.mu.Unlock()
+				return cn, nil
+			}
+		}
+
+		wait := c.dialing[i]
+		c.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.mu.Lock()
+	}
+
+	done := make(chan struct{})
+	c.dialing[i] = done
+	c.mu.Unlock()
+
+	nc, err := c.dial(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dialing[i] = nil
+	close(done)
+	if err != nil {
+		return nil, err
+	}
+	if c.closed {
+		nc.Close()
+		return nil, ErrClosed
+	}
+
+	cn := newConn(nc)
+	c.conns[i] = cn
+	return cn, nil
+}
+
+type result struct {
+	line string
+	err  error
+}
+
+type conn struct {
+	nc net.Conn
+
+	mu      sync.Mutex
+	err     error
+	pending []chan result
+}
+
+func newConn(nc net.Conn) *conn {
+	cn := &conn{nc: nc}
+	go cn.readLoop()
+	return cn
+}
+
+func (cn *conn) broken() bool {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.err != nil
+}
+
+// Writes one request line and waits for its reply. Missing the deadline
+// poisons the connection, since every reply queued behind the late one would
+// otherwise be handed to the wrong request.
+func (cn *conn) roundTrip(ctx context.Context, request string) (string, error) {
+	ch := make(chan result, 1)
+
+	cn.mu.Lock()
+	if cn.err != nil {
+		err := cn.err
+		cn.mu.Unlock()
+		return "", err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.nc.SetWriteDeadline(deadline)
+	}
+	cn.pending = append(cn.pending, ch)
+	_, err := cn.nc.Write([]byte(request))
+	cn.mu.Unlock()
+
+	if err != nil {
+		cn.fail(err)
+		return "", err
+	}
+
+	select {
+	case r := <-ch:
+		return r.line, r.err
+	case <-ctx.Done():
+		cn.fail(ctx.Err())
+		return "", ctx.Err()
+	}
+}
+
+func (cn *conn) readLoop() {
+	reader := bufio.NewReader(cn.nc)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			cn.fail(err)
+			return
+		}
+
+		cn.mu.Lock()
+		if len(cn.pending) == 0 {
+			cn.mu.Unlock()
+			cn.fail(errors.New("quoteclient: unsolicited reply"))
+			return
+		}
+		ch := cn.pending[0]
+		cn.pending = cn.pending[1:]
+		cn.mu.Unlock()
+
+		ch <- result{line: line}
+	}
+}
+
+// Closes the connection and fails every request still waiting on it
+func (cn *conn) fail(err error) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	if cn.err != nil {
+		return
+	}
+	cn.err = err
+	cn.nc.Close()
+
+	for _, ch := range cn.pending {
+		ch <- result{err: err}
+	}
+	cn.pending = nil
+}
+
+// Fields are separated by spaces in requests and commas in replies, so they
+// must not contain either
+func validField(f string) bool {
+	return f != "" && !strings.ContainsAny(f, " ,\r\n")
+}
+
+func validStatus(status string) bool {
+	switch status {
+	case MARKET_OPEN, MARKET_CLOSED, MARKET_HALTED, MARKET_UNKNOWN:
+		return true
+	}
+	return false
+}
+
+// Parses a reply line of the form price,sym,user,timestamp,key and checks it
+// answers a request for sym by user made around now. An error reply of the
+// form ERROR,sym,user,status is returned as a *MarketError.
+func ParseReply(line string, sym string, user string, now time.Time) (Quote, error) {
+	fields := strings.Split(strings.TrimSuffix(line, "\n"), ",")
+	if fields[0] == "ERROR" {
+		return Quote{}, parseErrorReply(line, fields, sym, user)
+	}
+	if len(fields) != 5 {
+		return Quote{}, &ReplyError{Line: line, Err: ErrFieldCount}
+	}
+
+	price, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || math.IsNaN(price) || math.IsInf(price, 0) || price < 0 {
+		return Quote{}, &ReplyError{Line: line, Err: ErrBadPrice}
+	}
+
+	if fields[1] != sym {
+		return Quote{}, &ReplyError{Line: line, Err: ErrSymbolMismatch}
+	}
+	if fields[2] != user {
+		return Quote{}, &ReplyError{Line: line, Err: ErrUserMismatch}
+	}
+
+	timestamp, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || timestamp <= 0 {
+		return Quote{}, &ReplyError{Line: line, Err: ErrBadTimestamp}
+	}
+	skew := now.Sub(time.UnixMilli(timestamp))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return Quote{}, &ReplyError{Line: line, Err: ErrBadTimestamp}
+	}
+
+	if fields[4] == "" {
+		return Quote{}, &ReplyError{Line: line, Err: ErrEmptyKey}
+	}
+
+	return Quote{
+		Price:     price,
+		Sym:       fields[1],
+		User:      fields[2],
+		Timestamp: int(timestamp),
+		Cryptokey: fields[4],
+	}, nil
+}
+
+func parseErrorReply(line string, fields []string, sym string, user string) error {
+	if len(fields) != 4 {
+		return &ReplyError{Line: line, Err: ErrFieldCount}
+	}
+	if fields[1] != sym {
+		return &ReplyError{Line: line, Err: ErrSymbolMismatch}
+	}
+	if fields[2] != user {
+		return &ReplyError{Line: line, Err: ErrUserMismatch}
+	}
+	if !validStatus(fields[3]) || fields[3] == MARKET_OPEN {
+		return &ReplyError{Line: line, Err: ErrBadStatus}
+	}
+	return &MarketError{Sym: sym, Status: fields[3]}
+}
@@ -0,0 +1,317 @@
+package quoteclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Serves quote requests the way the quote server does, answering each line in
+// order after a random delay so requests pile up on the connection
+func startQuoteServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveQuotes(nc)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveQuotes(nc net.Conn) {
+	defer nc.Close()
+
+	reader := bufio.NewReader(nc)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		sym, user, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+
+		time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+		fmt.Fprintf(nc, "%d.%02d,%s,%s,%d,key-%s-%s\n", len(sym), len(user), sym, user, time.Now().UnixMilli(), sym, user)
+	}
+}
+
+func TestConcurrentQuotesMatchTheirReplies(t *testing.T) {
+	c := NewClient(startQuoteServer(t), 2, 5*time.Second)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64*8)
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 8; j++ {
+				sym := fmt.Sprintf("S%d", i)
+				user := fmt.Sprintf("user%d", j)
+
+				q, err := c.Quote(context.Background(), sym, user)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if q.Sym != sym || q.User != user || q.Cryptokey != "key-"+sym+"-"+user {
+					errs <- fmt.Errorf("asked for %s %s, got %+v", sym, user, q)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestRequestsArePipelined(t *testing.T) {
+	c := NewClient(startQuoteServer(t), 1, 5*time.Second)
+	defer c.Close()
+
+	// Wait for the connection so every request below shares it
+	if _, err := c.Quote(context.Background(), "ABC", "warmup"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := fmt.Sprintf("user%d", i)
+			if _, err := c.Quote(context.Background(), "ABC", user); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	cn := c.conns[0]
+	c.mu.Unlock()
+	if cn == nil || cn.broken() {
+		t.Fatal("connection was not reused")
+	}
+}
+
+func TestReplyForAnotherRequestPoisonsTheConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		nc, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+		bufio.NewReader(nc).ReadString('\n')
+		fmt.Fprintf(nc, "1.00,XYZ,someone,%d,key\n", time.Now().UnixMilli())
+		time.Sleep(time.Second)
+	}()
+
+	c := NewClient(ln.Addr().String(), 1, 5*time.Second)
+	defer c.Close()
+
+	_, err = c.Quote(context.Background(), "ABC", "user")
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) {
+		t.Fatalf("got %v, want a *ReplyError", err)
+	}
+
+	c.mu.Lock()
+	cn := c.conns[0]
+	c.mu.Unlock()
+	if !cn.broken() {
+		t.Fatal("connection still in use after an out of step reply")
+	}
+}
+
+func TestSlowDialDoesNotBlockHealthyConnections(t *testing.T) {
+	addr := startQuoteServer(t)
+	c := NewClient(addr, 2, 5*time.Second)
+	defer c.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	var dials int32
+	c.dial = func(ctx context.Context) (net.Conn, error) {
+		// Only the second slot's dial hangs
+		if atomic.AddInt32(&dials, 1) == 2 {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	if _, err := c.Quote(context.Background(), "ABC", "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lands on the second slot and hangs dialing it
+	go c.Quote(context.Background(), "ABC", "second")
+	waitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.dialing[1] != nil
+	})
+
+	for i := 0; i < 4; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := c.Quote(ctx, "ABC", fmt.Sprintf("user%d", i))
+		cancel()
+		if err != nil {
+			t.Fatalf("quote while another slot was dialing: %s", err)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	ts := fmt.Sprint(now.UnixMilli())
+
+	tests := []struct {
+		name string
+		line string
+		want error
+	}{
+		{"valid", "12.34,ABC,alice," + ts + ",key\n", nil},
+		{"valid without newline", "12.34,ABC,alice," + ts + ",key", nil},
+		{"too few fields", "12.34,ABC,alice," + ts + "\n", ErrFieldCount},
+		{"too many fields", "12.34,ABC,alice," + ts + ",key,extra\n", ErrFieldCount},
+		{"empty line", "\n", ErrFieldCount},
+		{"non-numeric price", "abc,ABC,alice," + ts + ",key\n", ErrBadPrice},
+		{"negative price", "-1,ABC,alice," + ts + ",key\n", ErrBadPrice},
+		{"NaN price", "NaN,ABC,alice," + ts + ",key\n", ErrBadPrice},
+		{"infinite price", "Inf,ABC,alice," + ts + ",key\n", ErrBadPrice},
+		{"non-numeric timestamp", "12.34,ABC,alice,yesterday,key\n", ErrBadTimestamp},
+		{"fractional timestamp", "12.34,ABC,alice," + ts + ".5,key\n", ErrBadTimestamp},
+		{"zero timestamp", "12.34,ABC,alice,0,key\n", ErrBadTimestamp},
+		{"skewed timestamp", "12.34,ABC,alice," + fmt.Sprint(now.Add(-time.Hour).UnixMilli()) + ",key\n", ErrBadTimestamp},
+		{"other symbol", "12.34,XYZ,alice," + ts + ",key\n", ErrSymbolMismatch},
+		{"other user", "12.34,ABC,bob," + ts + ",key\n", ErrUserMismatch},
+		{"empty key", "12.34,ABC,alice," + ts + ",\n", ErrEmptyKey},
+		{"error reply for other symbol", "ERROR,XYZ,alice,HALTED\n", ErrSymbolMismatch},
+		{"error reply for other user", "ERROR,ABC,bob,HALTED\n", ErrUserMismatch},
+		{"error reply with too few fields", "ERROR,ABC,alice\n", ErrFieldCount},
+		{"error reply with open status", "ERROR,ABC,alice,OPEN\n", ErrBadStatus},
+		{"error reply with unknown status", "ERROR,ABC,alice,LUNCH\n", ErrBadStatus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseReply(tt.line, "ABC", "alice", now)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if q.Price != 12.34 || q.Sym != "ABC" || q.User != "alice" || q.Timestamp != int(now.UnixMilli()) || q.Cryptokey != "key" {
+					t.Fatalf("got %+v", q)
+				}
+				return
+			}
+
+			var replyErr *ReplyError
+			if !errors.As(err, &replyErr) || !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want a *ReplyError for %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrorReply(t *testing.T) {
+	for _, status := range []string{MARKET_CLOSED, MARKET_HALTED, MARKET_UNKNOWN} {
+		_, err := ParseReply("ERROR,ABC,alice,"+status+"\n", "ABC", "alice", time.Now())
+
+		var marketErr *MarketError
+		if !errors.As(err, &marketErr) || marketErr.Sym != "ABC" || marketErr.Status != status {
+			t.Errorf("got %v, want a *MarketError for %s", err, status)
+		}
+	}
+}
+
+func FuzzParseReply(f *testing.F) {
+	now := time.UnixMilli(1700000000000)
+	ts := fmt.Sprint(now.UnixMilli())
+
+	f.Add("12.34,ABC,alice," + ts + ",key\n")
+	f.Add("0,ABC,alice," + ts + ",key")
+	f.Add("ERROR,ABC,alice,HALTED\n")
+	f.Add("ERROR,ABC,alice,OPEN\n")
+	f.Add("ERROR\n")
+	f.Add("12.34,ABC,alice," + ts + "\n")
+	f.Add("1e400,ABC,alice," + ts + ",key\n")
+	f.Add("12.34,ABC,alice,-1,key\n")
+	f.Add(",,,,\n")
+	f.Add("")
+	f.Add("\n\n")
+
+	sentinels := []error{ErrFieldCount, ErrBadPrice, ErrBadTimestamp, ErrSymbolMismatch, ErrUserMismatch, ErrEmptyKey, ErrBadStatus}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		q, err := ParseReply(line, "ABC", "alice", now)
+		if err == nil {
+			if q.Sym != "ABC" || q.User != "alice" || q.Cryptokey == "" || q.Price < 0 {
+				t.Fatalf("accepted %q as %+v", line, q)
+			}
+			return
+		}
+
+		var marketErr *MarketError
+		if errors.As(err, &marketErr) {
+			return
+		}
+
+		var replyErr *ReplyError
+		if !errors.As(err, &replyErr) {
+			t.Fatalf("untyped error %v for %q", err, line)
+		}
+		for _, sentinel := range sentinels {
+			if errors.Is(err, sentinel) {
+				return
+			}
+		}
+		t.Fatalf("reply error %v for %q wraps no known reason", err, line)
+	})
+}
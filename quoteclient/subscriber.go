@@ -0,0 +1,203 @@
+package quoteclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MIN_RECONNECT_BACKOFF = 100 * time.Millisecond
+	MAX_RECONNECT_BACKOFF = 30 * time.Second
+)
+
+// A Subscriber holds its own connection to the quote server, separate from
+// the request pool, and receives the price updates the server pushes for the
+// symbols it is subscribed to. If the connection breaks it redials with
+// backoff and subscribes to the same symbols again.
+type Subscriber struct {
+	addr    string
+	user    string
+	updates chan Quote
+
+	mu     sync.Mutex
+	nc     net.Conn
+	syms   map[string]bool
+	closed bool
+}
+
+// Starts a subscriber receiving updates on behalf of user
+func NewSubscriber(addr string, user string) (*Subscriber, error) {
+	if !validField(user) {
+		return nil, ErrInvalidRequest
+	}
+
+	s := &Subscriber{addr: addr, user: user, updates: make(chan Quote, 64), syms: map[string]bool{}}
+	go s.run()
+	return s, nil
+}
+
+// Pushed quotes, in the order they arrived
+func (s *Subscriber) Updates() <-chan Quote {
+	return s.updates
+}
+
+func (s *Subscriber) Subscribe(sym string) error {
+	if !validField(sym) {
+		return ErrInvalidRequest
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.syms[sym] {
+		return nil
+	}
+	s.syms[sym] = true
+	return s.send("SUBSCRIBE " + sym + " " + s.user + "\n")
+}
+
+func (s *Subscriber) Unsubscribe(sym string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.syms[sym] {
+		return nil
+	}
+	delete(s.syms, sym)
+	return s.send("UNSUBSCRIBE " + sym + "\n")
+}
+
+// The symbols currently subscribed to
+func (s *Subscriber) Symbols() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	syms := make([]string, 0, len(s.syms))
+	for sym := range s.syms {
+		syms = append(syms, sym)
+	}
+	return syms
+}
+
+func (s *Subscriber) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.nc != nil {
+		s.nc.Close()
+	}
+}
+
+func (s *Subscriber) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Writes a command if connected. While disconnected the command is dropped;
+// the symbol set is replayed on reconnect instead.
+func (s *Subscriber) send(line string) error {
+	if s.nc == nil {
+		return nil
+	}
+	_, err := s.nc.Write([]byte(line))
+	return err
+}
+
+func (s *Subscriber) run() {
+	defer close(s.updates)
+
+	backoff := MIN_RECONNECT_BACKOFF
+	for !s.isClosed() {
+		nc, err := s.connect()
+		if err != nil {
+			log.Printf("quoteclient: subscriber connecting: %s\n", err)
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > MAX_RECONNECT_BACKOFF {
+				backoff = MAX_RECONNECT_BACKOFF
+			}
+			continue
+		}
+		backoff = MIN_RECONNECT_BACKOFF
+
+		if err := s.read(nc); err != nil && !s.isClosed() {
+			log.Printf("quoteclient: subscriber: %s\n", err)
+		}
+
+		s.mu.Lock()
+		s.nc = nil
+		s.mu.Unlock()
+		nc.Close()
+	}
+}
+
+// Dials and replays the current subscriptions
+func (s *Subscriber) connect() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		nc.Close()
+		return nil, ErrClosed
+	}
+	s.nc = nc
+	for sym := range s.syms {
+		if err := s.send("SUBSCRIBE " + sym + " " + s.user + "\n"); err != nil {
+			nc.Close()
+			s.nc = nil
+			return nil, err
+		}
+	}
+	return nc, nil
+}
+
+func (s *Subscriber) read(nc net.Conn) error {
+	reader := bufio.NewReader(nc)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		update, found := strings.CutPrefix(line, "UPDATE ")
+		if !found {
+			return errors.New("unexpected line from quote server")
+		}
+
+		fields := strings.Split(update, ",")
+		if len(fields) < 2 {
+			return &ReplyError{Line: line, Err: ErrFieldCount}
+		}
+
+		s.mu.Lock()
+		subscribed := s.syms[fields[1]]
+		s.mu.Unlock()
+		if !subscribed {
+			// Update sent before an UNSUBSCRIBE reached the server
+			continue
+		}
+
+		q, err := ParseReply(update, fields[1], s.user, time.Now())
+		if err != nil {
+			return err
+		}
+		s.updates <- q
+	}
+}
@@ -0,0 +1,3 @@
+module quotesig
+
+go 1.20
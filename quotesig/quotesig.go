@@ -0,0 +1,76 @@
+// Package quotesig signs and verifies the cryptokey attached to every quote.
+// The quote server signs the symbol, price and timestamp of each quote with an
+// Ed25519 private key and sends the signature as the cryptokey; anyone holding
+// the matching public key can then check that a quote is genuine and has not
+// been altered.
+//
+// The user a quote was asked for is not signed. Quotes are shared between
+// users, by the polling service coalescing requests for a symbol and through
+// the quote cache, so the user on a quote is whoever asked for it first, not
+// the only user allowed to trade on it.
+package quotesig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBadKey       = errors.New("quotesig: malformed key")
+	ErrBadSignature = errors.New("quotesig: signature does not match quote")
+)
+
+// The signed bytes. The symbol is length prefixed so no choice of it can be
+// mistaken for another quote.
+func message(sym string, priceInCents int64, timestamp int64) []byte {
+	return []byte(fmt.Sprintf("%d:%s,%d,%d", len(sym), sym, priceInCents, timestamp))
+}
+
+// Signs a quote, returning the cryptokey to send with it
+func Sign(priv ed25519.PrivateKey, sym string, priceInCents int64, timestamp int64) string {
+	sig := ed25519.Sign(priv, message(sym, priceInCents, timestamp))
+	return base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Checks that cryptokey is a valid signature of the quote
+func Verify(pub ed25519.PublicKey, sym string, priceInCents int64, timestamp int64, cryptokey string) error {
+	sig, err := base64.RawURLEncoding.DecodeString(cryptokey)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrBadSignature
+	}
+	if !ed25519.Verify(pub, message(sym, priceInCents, timestamp), sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Makes a new key pair, encoded the way ParsePrivateKey and ParsePublicKey
+// expect
+func GenerateKeys() (privateKey string, publicKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(priv.Seed()), base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// Decodes a base64 encoded 32 byte private key seed
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrBadKey
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Decodes a base64 encoded public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	pub, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, ErrBadKey
+	}
+	return ed25519.PublicKey(pub), nil
+}
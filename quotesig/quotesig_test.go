@@ -0,0 +1,124 @@
+package quotesig
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKeys(t *testing.T) (ed25519.PrivateKey, ed25519.PublicKey) {
+	t.Helper()
+
+	privateKey, publicKey, err := GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ParsePublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, pub
+}
+
+func TestSignVerify(t *testing.T) {
+	priv, pub := testKeys(t)
+
+	key := Sign(priv, "ABC", 1234, 1700000000000)
+	if err := Verify(pub, "ABC", 1234, 1700000000000, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeneratedKeysMatch(t *testing.T) {
+	priv, pub := testKeys(t)
+
+	if !pub.Equal(priv.Public()) {
+		t.Fatal("public key doesn't belong to the private key")
+	}
+}
+
+func TestTamperedQuoteFails(t *testing.T) {
+	priv, pub := testKeys(t)
+	key := Sign(priv, "ABC", 1234, 1700000000000)
+
+	tests := []struct {
+		name      string
+		sym       string
+		price     int64
+		timestamp int64
+	}{
+		{"symbol", "ABD", 1234, 1700000000000},
+		{"symbol prefix", "AB", 1234, 1700000000000},
+		{"price", "ABC", 1235, 1700000000000},
+		{"timestamp", "ABC", 1234, 1700000000001},
+	}
+	for _, tt := range tests {
+		if err := Verify(pub, tt.sym, tt.price, tt.timestamp, key); err != ErrBadSignature {
+			t.Errorf("%s changed: got %v, want ErrBadSignature", tt.name, err)
+		}
+	}
+}
+
+func TestWrongKeyFails(t *testing.T) {
+	priv, _ := testKeys(t)
+	_, otherPub := testKeys(t)
+
+	key := Sign(priv, "ABC", 1234, 1700000000000)
+	if err := Verify(otherPub, "ABC", 1234, 1700000000000, key); err != ErrBadSignature {
+		t.Fatalf("got %v, want ErrBadSignature", err)
+	}
+}
+
+func TestMalformedSignatureFails(t *testing.T) {
+	priv, pub := testKeys(t)
+	key := Sign(priv, "ABC", 1234, 1700000000000)
+	sig, _ := base64.RawURLEncoding.DecodeString(key)
+
+	for name, bad := range map[string]string{
+		"empty":         "",
+		"not base64":    "!!!" + key[3:],
+		"padded base64": base64.URLEncoding.EncodeToString(sig),
+		"short":         key[:len(key)-4],
+		"long":          key + "AAAA",
+		"random bytes":  strings.Repeat("A", len(key)),
+	} {
+		if err := Verify(pub, "ABC", 1234, 1700000000000, bad); err != ErrBadSignature {
+			t.Errorf("%s: got %v, want ErrBadSignature", name, err)
+		}
+	}
+}
+
+func TestParseKeysRejectMalformed(t *testing.T) {
+	short := base64.StdEncoding.EncodeToString(make([]byte, 31))
+	long := base64.StdEncoding.EncodeToString(make([]byte, 33))
+
+	for name, bad := range map[string]string{
+		"empty":      "",
+		"not base64": "not base64!",
+		"short":      short,
+		"long":       long,
+		// A whole private key rather than its 32 byte seed
+		"full key": base64.StdEncoding.EncodeToString(make([]byte, ed25519.PrivateKeySize)),
+	} {
+		if _, err := ParsePrivateKey(bad); err != ErrBadKey {
+			t.Errorf("private key %s: got %v, want ErrBadKey", name, err)
+		}
+	}
+
+	for name, bad := range map[string]string{
+		"empty":       "",
+		"not base64":  "not base64!",
+		"short":       short,
+		"long":        long,
+		"private key": base64.StdEncoding.EncodeToString(make([]byte, ed25519.PrivateKeySize)),
+	} {
+		if _, err := ParsePublicKey(bad); err != ErrBadKey {
+			t.Errorf("public key %s: got %v, want ErrBadKey", name, err)
+		}
+	}
+}
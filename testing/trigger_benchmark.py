@@ -0,0 +1,40 @@
+import time
+
+import requests
+
+""" Measures how long one trigger evaluation pass of the polling service takes
+as the number of armed orders grows. Orders are spread over a fixed set of
+symbols and armed as buys at price 0 so they never fire.
+"""
+
+base_url = "http://localhost:8081"
+symbols = [f"S{i:02d}" for i in range(20)]
+order_counts = [10, 100, 500, 1000, 2000]
+
+
+def arm(n):
+   for i in range(n):
+      order = {"ID": f"bench_user_{i}", "Stock": symbols[i % len(symbols)], "Amount": 1, "Price": 0, "Type": "buy"}
+      requests.post(f"{base_url}/new_limit", json=order)
+
+
+def disarm(n):
+   for i in range(n):
+      requests.delete(f"{base_url}/active_orders/bench_user_{i}/buy/{symbols[i % len(symbols)]}")
+
+
+def wait_for_tick(n):
+   # Waits until a pass over all n orders has been recorded
+   while True:
+      stats = requests.get(f"{base_url}/tick_stats").json()
+      if stats["orders"] == n:
+         return stats
+      time.sleep(0.5)
+
+
+print("orders  symbols  tick latency (ms)")
+for n in order_counts:
+   arm(n)
+   stats = wait_for_tick(n)
+   print(f"{stats['orders']:>6}  {stats['symbols']:>7}  {stats['durationMs']:>17.2f}")
+   disarm(n)
@@ -31,15 +31,27 @@ type logEntry struct {
 	DebugMessage    string  `xml:"debugMessage" json:"debugMessage"`
 }
 
-func logEvent(logEntry logEntry) {
+// Writes an entry to the audit log; swapped out by tests, which have no database
+var logEvent = writeLogEvent
+
+func writeLogEvent(logEntry logEntry) {
 	switch logEntry.LogType {
-	case USERCOMMAND, SYS_EVENT:
+	case USERCOMMAND:
 		resp := insert("logs", bson.D{{"LogType", logEntry.LogType}, {"Timestamp", logEntry.Timestamp}, {"Server", logEntry.Server},
 			{"TransactionNum", logEntry.TransactionNum}, {"Command", logEntry.Command}, {"Username", logEntry.Username},
 			{"StockSymbol", logEntry.StockSymbol}, {"Filename", logEntry.Filename}, {"Funds", logEntry.Funds}})
 		if resp != "ok" {
 			log.Fatal("Write to DB error")
 		}
+	case SYS_EVENT:
+		// Also records the quote a cache-served command used
+		resp := insert("logs", bson.D{{"LogType", logEntry.LogType}, {"Timestamp", logEntry.Timestamp}, {"Server", logEntry.Server},
+			{"TransactionNum", logEntry.TransactionNum}, {"Command", logEntry.Command}, {"Username", logEntry.Username},
+			{"StockSymbol", logEntry.StockSymbol}, {"Filename", logEntry.Filename}, {"Funds", logEntry.Funds},
+			{"Price", logEntry.Price}, {"QuoteServerTime", logEntry.QuoteServerTime}, {"Cryptokey", logEntry.Cryptokey}})
+		if resp != "ok" {
+			log.Fatal("Write to DB error")
+		}
 	case QUOTESERVER:
 		resp := insert("logs", bson.D{{"LogType", logEntry.LogType}, {"Timestamp", logEntry.Timestamp}, {"Server", logEntry.Server},
 			{"TransactionNum", logEntry.TransactionNum}, {"Price", logEntry.Price}, {"StockSymbol", logEntry.StockSymbol},
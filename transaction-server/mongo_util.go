@@ -65,7 +65,13 @@ func mongo_read_logs(v []bson.D) []logEntry {
 				}
 			case int64:
 				{
-					e.Timestamp = d
+					if tempk == "Timestamp" {
+						e.Timestamp = d
+					}
+					// Quote server times in ms don't fit in an int32
+					if tempk == "QuoteServerTime" {
+						e.QuoteServerTime = int(d)
+					}
 				}
 			case int32:
 				{
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Trigger requests run under the user's lock, so a polling service that stops
+// answering must not hold the user up for longer than this
+var polling_http_client = &http.Client{Timeout: 5 * time.Second}
+
+// Fetches the triggers the polling service is currently evaluating for the given user
+func fetchActiveOrders(pollingService string, id string) ([]LimitOrder, error) {
+	req, err := http.NewRequest(http.MethodGet, pollingService+"/active_orders/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := polling_http_client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	reads, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("polling service returned " + res.Status)
+	}
+
+	var orders []LimitOrder
+	if err := json.Unmarshal(reads, &orders); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// Hands a trigger to the polling service to evaluate. It has only been armed
+// if this returns nil, as the polling service refuses orders it can't store.
+func armTrigger(pollingService string, o LimitOrder) error {
+	parsedJson, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+
+	res, err := polling_http_client.Post(pollingService+"/new_limit", "application/json", bytes.NewBuffer(parsedJson))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	reads, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return errors.New("polling service returned " + res.Status + ": " + string(reads))
+	}
+	return nil
+}
+
+// Cancels an armed trigger in the polling service. Reports whether a matching
+// trigger existed.
+func cancelActiveOrder(pollingService string, id string, orderType string, stock string) (bool, error) {
+	req, err := http.NewRequest(http.MethodDelete, pollingService+"/active_orders/"+id+"/"+orderType+"/"+stock, nil)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := polling_http_client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, errors.New("polling service returned " + res.Status)
+	}
+}
+
+// Whether a symbol is trading, as reported by the polling service
+const (
+	MARKET_OPEN    = "OPEN"
+	MARKET_CLOSED  = "CLOSED"
+	MARKET_HALTED  = "HALTED"
+	MARKET_UNKNOWN = "UNKNOWN"
+)
+
+// A quote refused because its stock isn't trading
+type marketError struct {
+	stock  string
+	status string
+}
+
+func (e *marketError) Error() string {
+	switch e.status {
+	case MARKET_UNKNOWN:
+		return "Unknown stock " + e.stock
+	case MARKET_HALTED:
+		return "Trading halted for " + e.stock
+	}
+	return "Market closed for " + e.stock
+}
+
+// Reads the status the polling service refused a quote with, if it refused
+// it because the stock isn't trading
+func marketErrorFrom(stock string, res *http.Response, body []byte) error {
+	if res.StatusCode != http.StatusNotFound && res.StatusCode != http.StatusConflict {
+		return nil
+	}
+
+	var status string
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil
+	}
+	return &marketError{stock: stock, status: status}
+}
+
+// How long a market status is reused for. Halts take effect within this long.
+const MARKET_STATUS_TTL = time.Second
+
+type marketStatusEntry struct {
+	status    string
+	fetchedAt time.Time
+}
+
+var market_statuses = map[string]marketStatusEntry{}
+var market_statuses_mu sync.Mutex
+
+// Whether stock is trading, asking the polling service at most once per
+// MARKET_STATUS_TTL so trades on cached quotes don't each cost a round trip
+// to the quote server
+func marketStatus(pollingService string, stock string) (string, error) {
+	market_statuses_mu.Lock()
+	entry, found := market_statuses[stock]
+	market_statuses_mu.Unlock()
+	if found && time.Since(entry.fetchedAt) < MARKET_STATUS_TTL {
+		return entry.status, nil
+	}
+
+	status, err := fetchMarketStatus(pollingService, stock)
+	if err != nil {
+		return "", err
+	}
+
+	market_statuses_mu.Lock()
+	market_statuses[stock] = marketStatusEntry{status: status, fetchedAt: time.Now()}
+	market_statuses_mu.Unlock()
+	return status, nil
+}
+
+// Asks the polling service whether stock is trading
+func fetchMarketStatus(pollingService string, stock string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, pollingService+"/market/"+stock, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := quote_http_client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	reads, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New("polling service returned " + res.Status + ": " + string(reads))
+	}
+
+	var status struct {
+		Status string
+	}
+	if err := json.Unmarshal(reads, &status); err != nil {
+		return "", err
+	}
+	return status.Status, nil
+}
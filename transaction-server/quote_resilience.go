@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Quote requests to the polling service are retried with jittered exponential
+// backoff and guarded by a circuit breaker. Once the breaker opens, requests
+// fail fast until the cooldown has passed, after which a single probe is let
+// through (half-open) to decide whether to close it again. When every attempt
+// fails, the last quote fetched for the symbol is served instead, as long as it
+// is still within its validity window and no older than the caller allows, and
+// flagged as degraded.
+
+var quote_retries = 2
+var quote_backoff = 100 * time.Millisecond
+var quote_fallback = true
+var quote_breaker = &circuitBreaker{threshold: 5, cooldown: 10 * time.Second}
+
+var quote_http_client = &http.Client{Timeout: 5 * time.Second}
+
+var errCircuitOpen = errors.New("quote service circuit open")
+
+// A quote request the polling service turned down as invalid, such as one for
+// a malformed symbol. Like a marketError it is an answer about the request
+// rather than a failure of the service.
+type quoteRefused struct {
+	status string
+	reason string
+}
+
+func (e *quoteRefused) Error() string {
+	return "polling service refused quote: " + e.status + ": " + e.reason
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+}
+
+// Reports whether a request may go through, moving an open breaker to
+// half-open once its cooldown has passed
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return errCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		// Only the one probe is allowed while half-open
+		return errCircuitOpen
+	}
+	return nil
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+var last_quotes = map[string]quote_hit{}
+var last_quotes_mu sync.Mutex
+
+func rememberQuote(stock string, q quote_hit) {
+	last_quotes_mu.Lock()
+	defer last_quotes_mu.Unlock()
+
+	last_quotes[stock] = q
+}
+
+// Returns the last quote fetched for stock if it is still valid and at most
+// maxAge old
+func validLastQuote(stock string, maxAge time.Duration) (quote_hit, bool) {
+	last_quotes_mu.Lock()
+	defer last_quotes_mu.Unlock()
+
+	q, found := last_quotes[stock]
+	if !found {
+		return quote_hit{}, false
+	}
+	now := time.Now()
+	if !now.Before(q.cached().ExpiresAt()) || q.cached().Age(now) > maxAge {
+		return quote_hit{}, false
+	}
+	return q, true
+}
+
+// Asks the polling service for a quote, retrying and falling back as described above
+func requestQuoteResilient(pollingService string, transactionNum int, id string, stock string, maxAge time.Duration) (quote_hit, error) {
+	var lastErr error
+	for attempt := 0; attempt <= quote_retries; attempt++ {
+		if attempt > 0 {
+			backoff := quote_backoff << (attempt - 1)
+			time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)))
+		}
+
+		if lastErr = quote_breaker.allow(); lastErr == nil {
+			var q quote_hit
+			q, lastErr = requestQuote(pollingService, id, stock, transactionNum)
+			if lastErr == nil {
+				quote_breaker.success()
+				rememberQuote(stock, q)
+				return q, nil
+			}
+
+			// A stock that isn't trading or a request that isn't valid is a
+			// definite answer, not a failure; retrying won't change it and the
+			// last quote must not be served in its place
+			if definiteAnswer(lastErr) {
+				quote_breaker.success()
+				return quote_hit{}, lastErr
+			}
+			quote_breaker.failure()
+		}
+
+		// Logging failed attempt
+		errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transactionNum, Command: "QUOTE", Username: id, StockSymbol: stock, ErrorMessage: lastErr.Error()}
+		logEvent(errorLog)
+
+		if lastErr == errCircuitOpen {
+			break
+		}
+	}
+
+	if quote_fallback {
+		if q, found := validLastQuote(stock, maxAge); found {
+			q.Degraded = true
+			return q, nil
+		}
+	}
+
+	return quote_hit{}, lastErr
+}
+
+// A single quote request to the polling service
+func requestQuote(pollingService string, id string, stock string, transactionNum int) (quote_hit, error) {
+	var newQuote quote_hit
+
+	parsedJson, err := json.Marshal(req{Sym: stock, Username: id, TransactionNum: transactionNum})
+	if err != nil {
+		return newQuote, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, pollingService+"/quote", bytes.NewBuffer(parsedJson))
+	if err != nil {
+		return newQuote, err
+	}
+
+	res, err := quote_http_client.Do(request)
+	if err != nil {
+		return newQuote, err
+	}
+	defer res.Body.Close()
+
+	reads, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return newQuote, err
+	}
+
+	if err := marketErrorFrom(stock, res, reads); err != nil {
+		return newQuote, err
+	}
+	if res.StatusCode >= 400 && res.StatusCode < 500 {
+		var reason string
+		if err := json.Unmarshal(reads, &reason); err != nil {
+			reason = string(reads)
+		}
+		return newQuote, &quoteRefused{status: res.Status, reason: reason}
+	}
+	if res.StatusCode != http.StatusOK {
+		return newQuote, errors.New("polling service returned " + res.Status + ": " + string(reads))
+	}
+
+	if err := json.Unmarshal(reads, &newQuote); err != nil {
+		return newQuote, err
+	}
+
+	return newQuote, nil
+}
+
+func definiteAnswer(err error) bool {
+	var marketErr *marketError
+	var refused *quoteRefused
+	return errors.As(err, &marketErr) || errors.As(err, &refused)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeReply struct {
+	status int
+	body   interface{}
+}
+
+// A polling service answering /quote with its replies in turn, repeating the
+// last one
+type fakeQuotes struct {
+	mu       sync.Mutex
+	replies  []fakeReply
+	requests int
+}
+
+func (f *fakeQuotes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	i := f.requests
+	if i >= len(f.replies) {
+		i = len(f.replies) - 1
+	}
+	reply := f.replies[i]
+	f.requests++
+	f.mu.Unlock()
+
+	w.WriteHeader(reply.status)
+	json.NewEncoder(w).Encode(reply.body)
+}
+
+func (f *fakeQuotes) answer(replies ...fakeReply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = replies
+	f.requests = 0
+}
+
+func (f *fakeQuotes) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func startFakeQuotes(t *testing.T, replies ...fakeReply) (*fakeQuotes, string) {
+	t.Helper()
+
+	f := &fakeQuotes{replies: replies}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server.URL
+}
+
+// Sets the retry and fallback settings for the length of the test, with a
+// fresh breaker and nothing remembered or logged
+func useQuoteSettings(t *testing.T, retries int, fallback bool) {
+	t.Helper()
+
+	oldRetries, oldBackoff, oldFallback, oldBreaker, oldLog := quote_retries, quote_backoff, quote_fallback, quote_breaker, logEvent
+	t.Cleanup(func() {
+		quote_retries, quote_backoff, quote_fallback, quote_breaker, logEvent = oldRetries, oldBackoff, oldFallback, oldBreaker, oldLog
+	})
+
+	quote_retries = retries
+	quote_backoff = time.Millisecond
+	quote_fallback = fallback
+	quote_breaker = &circuitBreaker{threshold: 5, cooldown: time.Minute}
+	logEvent = func(logEntry) {}
+
+	last_quotes_mu.Lock()
+	last_quotes = map[string]quote_hit{}
+	last_quotes_mu.Unlock()
+}
+
+func quoteMadeAt(at time.Time) quote_hit {
+	return quote_hit{Timestamp: int(at.UnixMilli()), Price: 12.5, Cryptokey: "key", Sym: "ABC", User: "alice"}
+}
+
+var serverError = fakeReply{http.StatusInternalServerError, "down"}
+
+func TestBreakerOpensProbesAndCloses(t *testing.T) {
+	b := &circuitBreaker{threshold: 2, cooldown: 20 * time.Millisecond}
+
+	b.failure()
+	if err := b.allow(); err != nil {
+		t.Fatalf("open below the threshold: %v", err)
+	}
+	b.failure()
+	if err := b.allow(); err != errCircuitOpen {
+		t.Fatalf("at the threshold: got %v, want errCircuitOpen", err)
+	}
+
+	// After the cooldown a single probe goes through
+	time.Sleep(30 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe after cooldown: %v", err)
+	}
+	if err := b.allow(); err != errCircuitOpen {
+		t.Fatalf("second request while half-open: got %v, want errCircuitOpen", err)
+	}
+
+	// A failed probe opens it again straight away
+	b.failure()
+	if err := b.allow(); err != errCircuitOpen {
+		t.Fatalf("after a failed probe: got %v, want errCircuitOpen", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe after cooldown: %v", err)
+	}
+	b.success()
+	for i := 0; i < 3; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("after a successful probe: %v", err)
+		}
+	}
+
+	// Closing it forgot the earlier failures
+	b.failure()
+	if err := b.allow(); err != nil {
+		t.Fatalf("one failure after closing: %v", err)
+	}
+}
+
+func TestDefiniteAnswer(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&marketError{stock: "ABC", status: MARKET_HALTED}, true},
+		{&quoteRefused{status: "400 Bad Request", reason: "bad symbol"}, true},
+		{errCircuitOpen, false},
+		{errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		if got := definiteAnswer(tt.err); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteRetriedUntilItArrives(t *testing.T) {
+	useQuoteSettings(t, 2, false)
+	q := quoteMadeAt(time.Now())
+	quotes, url := startFakeQuotes(t, serverError, serverError, fakeReply{http.StatusOK, q})
+
+	got, err := requestQuoteResilient(url, 1, "alice", "ABC", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != q {
+		t.Fatalf("got %+v, want %+v", got, q)
+	}
+	if n := quotes.count(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+	if quote_breaker.failures != 0 {
+		t.Errorf("%d breaker failures left after a success", quote_breaker.failures)
+	}
+}
+
+func TestQuoteGivenUpAfterRetries(t *testing.T) {
+	useQuoteSettings(t, 2, false)
+	quotes, url := startFakeQuotes(t, serverError)
+
+	if _, err := requestQuoteResilient(url, 1, "alice", "ABC", time.Minute); err == nil {
+		t.Fatal("got a quote from a failing service")
+	}
+	if n := quotes.count(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+	if quote_breaker.failures != 3 {
+		t.Errorf("%d breaker failures, want 3", quote_breaker.failures)
+	}
+}
+
+func TestDefiniteAnswersAreNotRetried(t *testing.T) {
+	tests := []struct {
+		reply fakeReply
+		check func(error) bool
+	}{
+		{fakeReply{http.StatusBadRequest, "bad symbol"}, func(err error) bool {
+			var refused *quoteRefused
+			return errors.As(err, &refused) && refused.reason == "bad symbol"
+		}},
+		{fakeReply{http.StatusConflict, MARKET_HALTED}, func(err error) bool {
+			var marketErr *marketError
+			return errors.As(err, &marketErr) && marketErr.status == MARKET_HALTED
+		}},
+	}
+	for _, tt := range tests {
+		useQuoteSettings(t, 2, true)
+		// Not served in place of the answer
+		rememberQuote("ABC", quoteMadeAt(time.Now()))
+		quote_breaker.failures = 2
+
+		quotes, url := startFakeQuotes(t, tt.reply)
+		_, err := requestQuoteResilient(url, 1, "alice", "ABC", time.Minute)
+		if !tt.check(err) {
+			t.Errorf("%d: got %v", tt.reply.status, err)
+		}
+		if n := quotes.count(); n != 1 {
+			t.Errorf("%d: %d requests, want 1", tt.reply.status, n)
+		}
+		if quote_breaker.failures != 0 {
+			t.Errorf("%d: counted as a breaker failure", tt.reply.status)
+		}
+	}
+}
+
+func TestOpenBreakerFailsFast(t *testing.T) {
+	useQuoteSettings(t, 2, false)
+	quote_breaker.state = breakerOpen
+	quote_breaker.openedAt = time.Now()
+	quotes, url := startFakeQuotes(t, fakeReply{http.StatusOK, quoteMadeAt(time.Now())})
+
+	if _, err := requestQuoteResilient(url, 1, "alice", "ABC", time.Minute); err != errCircuitOpen {
+		t.Fatalf("got %v, want errCircuitOpen", err)
+	}
+	if n := quotes.count(); n != 0 {
+		t.Errorf("%d requests through an open breaker", n)
+	}
+}
+
+func TestLastQuoteFallback(t *testing.T) {
+	q := quoteMadeAt(time.Now().Add(-5 * time.Second))
+
+	tests := []struct {
+		name     string
+		fallback bool
+		maxAge   time.Duration
+		want     bool
+	}{
+		{"fallback", true, time.Minute, true},
+		{"fallback off", false, time.Minute, false},
+		{"too old for the caller", true, time.Second, false},
+	}
+	for _, tt := range tests {
+		useQuoteSettings(t, 0, tt.fallback)
+		quotes, url := startFakeQuotes(t, fakeReply{http.StatusOK, q})
+		if _, err := requestQuoteResilient(url, 1, "alice", "ABC", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		quotes.answer(serverError)
+		got, err := requestQuoteResilient(url, 1, "alice", "ABC", tt.maxAge)
+		if !tt.want {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if !got.Degraded || got.Price != q.Price || got.Timestamp != q.Timestamp {
+			t.Errorf("%s: got %+v, want the last quote flagged degraded", tt.name, got)
+		}
+	}
+}
+
+func TestExpiredLastQuoteIsNotServed(t *testing.T) {
+	useQuoteSettings(t, 0, true)
+	rememberQuote("ABC", quoteMadeAt(time.Now().Add(-2*time.Minute)))
+	_, url := startFakeQuotes(t, serverError)
+
+	if got, err := requestQuoteResilient(url, 1, "alice", "ABC", time.Hour); err == nil {
+		t.Fatalf("got %+v, want an error", got)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"math"
+	"quotesig"
+	"time"
+)
+
+// Loaded from QUOTE_VERIFY_KEY. When set, BUY and SELL only accept prices
+// from quotes carrying a valid quote server signature.
+var quote_verify_key ed25519.PublicKey
+
+var (
+	errQuoteSymbol  = errors.New("quote is for a different symbol")
+	errQuoteExpired = errors.New("quote is past its validity window")
+)
+
+// Checks that q is a quote for stock that can still be traded on and, if
+// verification is on, that it was signed by the quote server. A signature
+// alone would let an old quote be replayed for ever. Any user may trade on a
+// quote, as quotes are shared; see quotesig.
+func verifyQuote(stock string, q quote_hit, now time.Time) error {
+	if q.Sym != stock {
+		return errQuoteSymbol
+	}
+	if !now.Before(q.cached().ExpiresAt()) {
+		return errQuoteExpired
+	}
+	if quote_verify_key == nil {
+		return nil
+	}
+	return quotesig.Verify(quote_verify_key, q.Sym, int64(math.Round(q.Price*100)), int64(q.Timestamp), q.Cryptokey)
+}
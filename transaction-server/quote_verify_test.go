@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cache"
+	"crypto/ed25519"
+	"math"
+	"quotesig"
+	"testing"
+	"time"
+)
+
+// Turns signature checks on for the length of the test, returning the key
+// quotes must be signed with
+func useVerifyKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	privateKey, publicKey, err := quotesig.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := quotesig.ParsePrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := quotesig.ParsePublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldKey := quote_verify_key
+	t.Cleanup(func() { quote_verify_key = oldKey })
+	quote_verify_key = pub
+	return priv
+}
+
+func signedQuoteAt(priv ed25519.PrivateKey, at time.Time) quote_hit {
+	q := quoteMadeAt(at)
+	q.Cryptokey = quotesig.Sign(priv, q.Sym, int64(math.Round(q.Price*100)), int64(q.Timestamp))
+	return q
+}
+
+func TestVerifyQuote(t *testing.T) {
+	now := time.Now()
+	priv := useVerifyKey(t)
+	q := signedQuoteAt(priv, now.Add(-time.Second))
+
+	if err := verifyQuote("ABC", q, now); err != nil {
+		t.Fatalf("valid quote: %v", err)
+	}
+
+	// Quotes are shared, so one asked for by another user is still good
+	other := q
+	other.User = "bob"
+	if err := verifyQuote("ABC", other, now); err != nil {
+		t.Errorf("quote asked for by another user: %v", err)
+	}
+}
+
+func TestVerifyQuoteRejects(t *testing.T) {
+	now := time.Now()
+	priv := useVerifyKey(t)
+	q := signedQuoteAt(priv, now.Add(-time.Second))
+
+	unsigned := q
+	unsigned.Cryptokey = ""
+	altered := q
+	altered.Price += 0.01
+	expired := signedQuoteAt(priv, now.Add(-cache.MAX_QUOTE_VALIDITY_SECS*time.Second))
+
+	tests := []struct {
+		name  string
+		stock string
+		q     quote_hit
+		want  error
+	}{
+		{"wrong symbol", "XYZ", q, errQuoteSymbol},
+		{"expired", "ABC", expired, errQuoteExpired},
+		{"unsigned", "ABC", unsigned, quotesig.ErrBadSignature},
+		{"altered price", "ABC", altered, quotesig.ErrBadSignature},
+	}
+	for _, tt := range tests {
+		if err := verifyQuote(tt.stock, tt.q, now); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyQuoteWithoutKey(t *testing.T) {
+	now := time.Now()
+	q := quoteMadeAt(now.Add(-time.Second))
+	oldKey := quote_verify_key
+	t.Cleanup(func() { quote_verify_key = oldKey })
+	quote_verify_key = nil
+
+	// Unsigned quotes pass, but the symbol and expiry are still checked
+	if err := verifyQuote("ABC", q, now); err != nil {
+		t.Errorf("unsigned quote: %v", err)
+	}
+	if err := verifyQuote("XYZ", q, now); err != errQuoteSymbol {
+		t.Errorf("wrong symbol: got %v, want errQuoteSymbol", err)
+	}
+	if err := verifyQuote("ABC", quoteMadeAt(now.Add(-2*time.Minute)), now); err != errQuoteExpired {
+		t.Errorf("expired: got %v, want errQuoteExpired", err)
+	}
+}
@@ -1,17 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"cache"
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
-	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
 	"os"
+	"quotesig"
 	"reflect"
 	"strconv"
 	"time"
@@ -45,18 +43,31 @@ type accStatus struct {
 type req struct {
 	Sym      string `json:"Sym"`
 	Username string `json:"Username"`
+
+	// The command the quote is for, so its quote server hit is logged under it
+	TransactionNum int `json:"TransactionNum"`
 }
 
 type quote_hit struct {
 	Timestamp int     `json:"Timestamp"`
 	Price     float64 `json:"Price"`
 	Cryptokey string  `json:"Cryptokey"`
+	Sym       string  `json:"Sym"`
+	User      string  `json:"User"`               // user the quote server signed the quote for
+	Degraded  bool    `json:"Degraded,omitempty"` // served from the last known quote
+
+	// Seconds since the quote was made, and when it stops being valid (unix ms)
+	Age       float64 `json:"Age"`
+	ExpiresAt int64   `json:"ExpiresAt"`
 }
 
 type quote struct {
-	Stock string
-	Price float64
-	CKey  string // Crytohraphic key
+	Stock     string
+	Price     float64
+	CKey      string // Crytohraphic key
+	Degraded  bool   `json:",omitempty"`
+	Age       float64
+	ExpiresAt int64
 }
 
 type quoteInCache struct {
@@ -70,6 +81,9 @@ type LimitOrder struct {
 	Amount float64 `json:"amount"`
 	User   string  `json:"ID"`
 	Qty    float64 `json:"qty"`
+
+	// Given by the polling service when the trigger is armed
+	OrderId string `json:"orderId,omitempty"`
 }
 
 type order struct {
@@ -78,6 +92,9 @@ type order struct {
 	Amount float64 `json:"amount"`
 	Price  float64
 	Qty    int
+
+	// Set on orders fired by a trigger, see triggered_orders.go
+	OrderId string `json:"orderId,omitempty"`
 }
 
 type displayCmdData struct {
@@ -92,6 +109,9 @@ type logQSHit struct {
 	Timestamp int     `json:"timestamp"`
 	Price     float64 `json:"price"`
 	Cryptokey string  `json:"cryptokey"`
+
+	// Zero when the quote wasn't fetched for a command, e.g. to evaluate a trigger
+	TransactionNum int `json:"transactionNum"`
 }
 
 var quotes = []quote{}
@@ -122,30 +142,33 @@ func main() {
 	router.SetTrustedProxies(nil)
 
 	var db *mongo.Database
+	var quoteCache *cache.Metered
 	router.Use(func(ctx *gin.Context) {
 		ctx.Set("db", db)
+		ctx.Set("cache", quoteCache)
 		ctx.Set("pollingService", pollingService)
 		ctx.Next()
 	})
 
 	// User Commands
-	router.PUT("/users/addBal", addBalance)
+	router.PUT("/users/addBal", serializeUser, addBalance)
 	router.GET("/users/:id/quote/:stock", Quote)
-	router.POST("/users/buy", buyStock)
-	router.POST("/users/buy/commit", commitBuy)
-	router.DELETE("/users/:id/buy/cancel", cancelBuy)
-	router.POST("/users/sell", sellStock)
-	router.POST("/users/sell/commit", commitSell)
-	router.DELETE("/users/:id/sell/cancel", cancelSell)
-	router.POST("/users/set/:type", setAmount)
-	router.DELETE("/users/:id/set/:type/:stock/cancel", cancelSet)
-	router.POST("/users/set/:type/trigger", setTrigger)
+	router.POST("/users/buy", serializeUser, buyStock)
+	router.POST("/users/buy/commit", serializeUser, commitBuy)
+	router.DELETE("/users/:id/buy/cancel", serializeUser, cancelBuy)
+	router.POST("/users/sell", serializeUser, sellStock)
+	router.POST("/users/sell/commit", serializeUser, commitSell)
+	router.DELETE("/users/:id/sell/cancel", serializeUser, cancelSell)
+	router.POST("/users/set/:type", serializeUser, setAmount)
+	router.DELETE("/users/:id/set/:type/:stock/cancel", serializeUser, cancelSet)
+	router.POST("/users/set/:type/trigger", serializeUser, setTrigger)
 	router.POST("/dumplog", dumplog)
 	router.GET("/displaysummary/:id", displaySummary)
 
 	// Util routes
 	router.GET("/users/:id", getAccount)
 	router.GET("/health", healthcheck)
+	router.GET("/metrics", metrics)
 	router.POST("/log_qs_hit", log_qs_hit)
 
 	router.GET("/users", getAll)
@@ -153,13 +176,41 @@ func main() {
 	router.GET("/quotes", getQuotes)
 
 	bind := flag.String("bind", "localhost:8080", "host:port to listen on")
+	flag.IntVar(&quote_retries, "quote-retries", quote_retries, "retries for a failed quote request")
+	flag.DurationVar(&quote_backoff, "quote-backoff", quote_backoff, "base backoff between quote retries")
+	flag.BoolVar(&quote_fallback, "quote-fallback", quote_fallback, "serve the last valid quote when the quote service is down")
+	flag.IntVar(&quote_breaker.threshold, "quote-breaker-threshold", quote_breaker.threshold, "consecutive quote failures that open the circuit")
+	flag.DurationVar(&quote_breaker.cooldown, "quote-breaker-cooldown", quote_breaker.cooldown, "time the quote circuit stays open before probing")
+	flag.DurationVar(&user_lock_ttl, "user-lock-ttl", user_lock_ttl, "lease on a user's lock, renewed while a command runs")
+	flag.DurationVar(&user_lock_wait, "user-lock-wait", user_lock_wait, "how long a command waits for its user's lock")
 	flag.Parse()
 
+	if quote_retries < 0 {
+		log.Fatalln("-quote-retries can't be negative")
+	}
+	if quote_backoff < 0 {
+		log.Fatalln("-quote-backoff can't be negative")
+	}
+	if user_lock_ttl < MIN_USER_LOCK_TTL {
+		log.Fatalf("-user-lock-ttl must be at least %s\n", MIN_USER_LOCK_TTL)
+	}
+	if user_lock_wait <= 0 {
+		log.Fatalln("-user-lock-wait must be positive")
+	}
+
 	databaseUri, found := os.LookupEnv("DATABASE_URI")
 	if !found {
 		log.Fatalln("No DATABASE_URI")
 	}
 
+	if key, found := os.LookupEnv("QUOTE_VERIFY_KEY"); found {
+		var err error
+		quote_verify_key, err = quotesig.ParsePublicKey(key)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
 	mongoClient, err := connectDb(databaseUri)
 	if err != nil {
 		log.Fatalln(err)
@@ -167,6 +218,12 @@ func main() {
 
 	db = mongoClient.Database("daytrading")
 
+	quoteCache, err = cache.OpenFromEnv(context.Background())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer quoteCache.Close()
+
 	defer func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -193,8 +250,15 @@ func log_qs_hit(c *gin.Context) {
 		return
 	}
 
+	// Hits arrive through the polling service's outbox, possibly long after the
+	// command they were fetched for, so they carry its transaction number
+	transactionNum := qs_hit.TransactionNum
+	if transactionNum == 0 {
+		transactionNum = transaction_counter
+	}
+
 	// Logging quote server hit
-	QSHitLog := logEntry{LogType: QUOTESERVER, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Price: qs_hit.Price, StockSymbol: qs_hit.Sym, Username: qs_hit.Id, QuoteServerTime: qs_hit.Timestamp, Cryptokey: qs_hit.Cryptokey}
+	QSHitLog := logEntry{LogType: QUOTESERVER, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transactionNum, Price: qs_hit.Price, StockSymbol: qs_hit.Sym, Username: qs_hit.Id, QuoteServerTime: qs_hit.Timestamp, Cryptokey: qs_hit.Cryptokey}
 	logEvent(QSHitLog)
 }
 
@@ -256,6 +320,10 @@ func addBalance(c *gin.Context) {
 	}
 
 	if newBalDif.Amount >= 0 {
+		if !holdsUser(c, "ADD", newBalDif.ID) {
+			transaction_counter += 1
+			return
+		}
 		u := updateOne("users", bson.D{{"user_id", newBalDif.ID}}, bson.D{{"cash_balance", newBalDif.Amount}}, "$inc")
 		if u != "ok" {
 			panic(u)
@@ -278,75 +346,106 @@ func Quote(c *gin.Context) {
 	id := c.Param("id")
 	stock := c.Param("stock")
 
+	maxAge, ok := maxQuoteAge(c)
+	if !ok {
+		return
+	}
+
 	// Logging user command
-	quoteCmdLog := logEntry{LogType: USERCOMMAND, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: "QUOTE", Username: id, StockSymbol: stock}
+	transactionNum := transaction_counter
+	quoteCmdLog := logEntry{LogType: USERCOMMAND, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transactionNum, Command: "QUOTE", Username: id, StockSymbol: stock}
 	logEvent(quoteCmdLog)
+	transaction_counter += 1
 
-	theQuote := fetchQuote(c, id, stock)
+	theQuote, err := fetchQuote(c, "QUOTE", transactionNum, id, stock, maxAge)
+	if err != nil {
+		quoteUnavailable(c, transactionNum, id, stock, err)
+		return
+	}
 
 	var q quote
 
 	q.Price = theQuote.Price
 	q.Stock = stock
 	q.CKey = theQuote.Cryptokey
+	q.Degraded = theQuote.Degraded
+	q.Age = theQuote.Age
+	q.ExpiresAt = theQuote.ExpiresAt
 
 	c.IndentedJSON(http.StatusOK, q)
 }
 
-func fetchQuote(c *gin.Context, id string, stock string) quote_hit {
-	pollingService := c.MustGet("pollingService").(string)
-
-	// check if quote for specified stock exists
-	var newQuote quote_hit
-
-	val, err := cache.GetKeyWithStringVal(stock)
-
-	if val != "" {
-		newQuote.Price, err = strconv.ParseFloat(val, 64)
-		if err != nil {
-			fmt.Println("COULD NOT CONVERT")
-		}
-		return newQuote
+// Reads the optional max_age query parameter: how many seconds old a cached
+// or fallback quote may be. Defaults to the whole validity window; 0 always
+// asks the quote server.
+func maxQuoteAge(c *gin.Context) (time.Duration, bool) {
+	v := c.Query("max_age")
+	if v == "" {
+		return cache.MAX_QUOTE_VALIDITY_SECS * time.Second, true
 	}
-	// Not in cache
 
-	var s req
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || secs < 0 || math.IsNaN(secs) {
+		c.IndentedJSON(http.StatusBadRequest, "max_age must be a non-negative number of seconds")
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
 
-	s.Sym = stock
-	s.Username = id
+// The quote as the cache keeps it, which knows how long it is valid for
+func (q quote_hit) cached() cache.Quote {
+	return cache.Quote{Price: q.Price, Sym: q.Sym, Timestamp: int64(q.Timestamp), Cryptokey: q.Cryptokey, User: q.User}
+}
 
-	parsedJson, err := json.Marshal(s)
-	if err != nil {
-		panic(err)
-	}
+// Fills in the quote's age and expiry as of now
+func (q quote_hit) withValidity(now time.Time) quote_hit {
+	q.Age = q.cached().Age(now).Seconds()
+	q.ExpiresAt = q.cached().ExpiresAt().UnixMilli()
+	return q
+}
 
-	req, err := http.NewRequest(http.MethodPost, pollingService + "/quote", bytes.NewBuffer(parsedJson))
-	if err != nil {
-		panic(err)
-	}
+// Fetches a quote for the given command, from the cache if it holds one at
+// most maxAge old
+func fetchQuote(c *gin.Context, command string, transactionNum int, id string, stock string, maxAge time.Duration) (quote_hit, error) {
+	pollingService := c.MustGet("pollingService").(string)
+	quoteCache := c.MustGet("cache").(cache.Cache)
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		fmt.Println("ERROR")
-		fmt.Println(err)
-	}
+	// check if quote for specified stock exists
+	var newQuote quote_hit
 
-	reads, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println("ERROR")
-		fmt.Println(err)
+	cached, err := quoteCache.GetQuote(c.Request.Context(), stock)
+	if err == nil && cached.Age(time.Now()) <= maxAge {
+		// The cache knows nothing of halts or closing time, so trades on a
+		// cached price check the market first. A QUOTE isn't a trade and is
+		// served from the cache whether or not the stock is trading.
+		status := MARKET_OPEN
+		if command != "QUOTE" {
+			status, err = marketStatus(pollingService, stock)
+		}
+		if err == nil && status != MARKET_OPEN {
+			return newQuote, &marketError{stock: stock, status: status}
+		}
+		if err == nil {
+			// Logging the cached quote the command is served from, since it
+			// wasn't logged as a quote server hit for this user
+			cachedLog := logEntry{LogType: SYS_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transactionNum, Command: command, Username: id, StockSymbol: stock, Price: cached.Price, QuoteServerTime: int(cached.Timestamp), Cryptokey: cached.Cryptokey}
+			logEvent(cachedLog)
+
+			newQuote = quote_hit{Timestamp: int(cached.Timestamp), Price: cached.Price, Cryptokey: cached.Cryptokey, Sym: cached.Sym, User: cached.User}
+			return newQuote.withValidity(time.Now()), nil
+		}
+		// Without a market status the quote is fetched as if it weren't
+		// cached, which retries, falls back and finds out whether the stock
+		// is trading along the way
 	}
+	// Not in cache
 
-	json.Unmarshal(reads, &newQuote)
+	// The quote server hit is logged by the polling service through /log_qs_hit
+	q, err := requestQuoteResilient(pollingService, transactionNum, id, stock, maxAge)
 	if err != nil {
-		panic(err)
+		return q, err
 	}
-
-	// Logging quote server hit
-	QSHitLog := logEntry{LogType: QUOTESERVER, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Price: newQuote.Price, StockSymbol: stock, Username: id, QuoteServerTime: newQuote.Timestamp, Cryptokey: newQuote.Cryptokey}
-	logEvent(QSHitLog)
-
-	return newQuote
+	return q.withValidity(time.Now()), nil
 }
 
 func buyStock(c *gin.Context) {
@@ -358,8 +457,17 @@ func buyStock(c *gin.Context) {
 		return
 	}
 
+	maxAge, ok := maxQuoteAge(c)
+	if !ok {
+		return
+	}
+	if !retryableOrder(c, &buys, newOrder) {
+		return
+	}
+
 	// Logging user command
-	buyCmdLog := logEntry{LogType: USERCOMMAND, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: "BUY", Username: newOrder.ID, StockSymbol: newOrder.Stock, Funds: newOrder.Amount}
+	transactionNum := transaction_counter
+	buyCmdLog := logEntry{LogType: USERCOMMAND, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transactionNum, Command: "BUY", Username: newOrder.ID, StockSymbol: newOrder.Stock, Funds: newOrder.Amount}
 	logEvent(buyCmdLog)
 	transaction_counter += 1
 
@@ -368,7 +476,15 @@ func buyStock(c *gin.Context) {
 
 	// This would ideally go after checking if account has enough balance
 	// Fetching most current price for that stock
-	newOrder.Price = fetchQuote(c, newOrder.ID, newOrder.Stock).Price
+	theQuote, err := fetchQuote(c, "BUY", transactionNum, newOrder.ID, newOrder.Stock, maxAge)
+	if err != nil {
+		quoteUnavailable(c, transactionNum, newOrder.ID, newOrder.Stock, err)
+		return
+	}
+	if !quoteVerified(c, transactionNum, newOrder, theQuote) {
+		return
+	}
+	newOrder.Price = theQuote.Price
 
 	newOrder.Qty = int(math.Floor(newOrder.Amount))
 
@@ -391,7 +507,43 @@ func buyStock(c *gin.Context) {
 	}
 }
 
+// Replies to a command that needed a quote it could not get. Trading in a
+// stock that isn't trading is refused outright, as is a quote request the
+// polling service found invalid; anything else is a failure of the quote
+// service.
+func quoteUnavailable(c *gin.Context, transactionNum int, id string, stock string, err error) {
+	if !definiteAnswer(err) {
+		c.IndentedJSON(http.StatusServiceUnavailable, "Quote unavailable")
+		return
+	}
+
+	// Logging refused command
+	errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transactionNum, Username: id, StockSymbol: stock, ErrorMessage: err.Error()}
+	logEvent(errorLog)
+
+	var refused *quoteRefused
+	if errors.As(err, &refused) {
+		c.IndentedJSON(http.StatusBadRequest, refused.reason)
+		return
+	}
+	c.IndentedJSON(http.StatusForbidden, err.Error())
+}
+
+// Rejects the order if the quote its price comes from is forged or tampered with
+func quoteVerified(c *gin.Context, transactionNum int, o order, q quote_hit) bool {
+	if err := verifyQuote(o.Stock, q, time.Now()); err != nil {
+		// Logging rejected quote
+		errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transactionNum, Username: o.ID, StockSymbol: o.Stock, Funds: o.Amount, ErrorMessage: err.Error()}
+		logEvent(errorLog)
+
+		c.IndentedJSON(http.StatusForbidden, "Quote failed verification")
+		return false
+	}
+	return true
+}
+
 func commitBuy(c *gin.Context) {
+	db := c.MustGet("db").(*mongo.Database)
 	var commitOrder order
 
 	// Calling BindJSON to bind the recieved JSON to new BalDif
@@ -406,7 +558,7 @@ func commitBuy(c *gin.Context) {
 	j := 0
 	for _, o := range buys {
 
-		if o.ID == commitOrder.ID {
+		if o.ID == commitOrder.ID && o.OrderId == commitOrder.OrderId {
 			match = true
 
 			// Logging user command
@@ -414,12 +566,19 @@ func commitBuy(c *gin.Context) {
 			logEvent(commitBuyCmdLog)
 
 			// change user balance
-			to_match := bson.D{{"user_id", o.ID}}
+			if !holdsUser(c, "COMMIT_BUY", commitOrder.ID) {
+				transaction_counter += 1
+				return
+			}
 			to_update := bson.D{{"cash_balance", -o.Amount}, {o.Stock, o.Qty}}
-			r := updateOne("users", to_match, to_update, "$inc")
-
-			if r != "ok" {
-				panic(r)
+			applied, err := applyOrder(db, o, to_update)
+			if err != nil {
+				panic(err)
+			}
+			if !applied {
+				buys = append(buys[:j], buys[j+1:]...)
+				c.IndentedJSON(http.StatusOK, ALREADY_COMMITTED)
+				break
 			}
 
 			// Logging account changes
@@ -427,7 +586,7 @@ func commitBuy(c *gin.Context) {
 			logEvent(commitBuyDBLog)
 
 			//remover order from orders
-			c.IndentedJSON(http.StatusOK, r)
+			c.IndentedJSON(http.StatusOK, "ok")
 
 			//possible memory leak
 			buys = append(buys[:j], buys[j+1:]...)
@@ -438,7 +597,7 @@ func commitBuy(c *gin.Context) {
 	}
 
 	// Logging error
-	if !match {
+	if !match && !committedBefore(c, commitOrder) {
 		// Logging user command
 		commitBuyCmdLog := logEntry{LogType: USERCOMMAND, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: "COMMIT_BUY", Username: commitOrder.ID}
 		logEvent(commitBuyCmdLog)
@@ -446,6 +605,8 @@ func commitBuy(c *gin.Context) {
 		// Logging command did not happen due to error
 		errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: "COMMIT_BUY", Username: commitOrder.ID}
 		logEvent(errorLog)
+
+		c.IndentedJSON(http.StatusForbidden, "No previous buy order")
 	}
 	transaction_counter += 1
 }
@@ -455,7 +616,7 @@ func cancelBuy(c *gin.Context) {
 	match := false
 	j := 0
 	for _, o := range buys {
-		if o.ID == id {
+		if o.ID == id && o.OrderId == "" {
 			match = true
 
 			// Logging user command
@@ -493,8 +654,17 @@ func sellStock(c *gin.Context) {
 		return
 	}
 
+	maxAge, ok := maxQuoteAge(c)
+	if !ok {
+		return
+	}
+	if !retryableOrder(c, &sells, newOrder) {
+		return
+	}
+
 	// Logging user command
-	sellCmdLog := logEntry{LogType: USERCOMMAND, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: "SELL", Username: newOrder.ID, StockSymbol: newOrder.Stock, Funds: newOrder.Amount}
+	transactionNum := transaction_counter
+	sellCmdLog := logEntry{LogType: USERCOMMAND, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transactionNum, Command: "SELL", Username: newOrder.ID, StockSymbol: newOrder.Stock, Funds: newOrder.Amount}
 	logEvent(sellCmdLog)
 	transaction_counter += 1
 
@@ -505,7 +675,15 @@ func sellStock(c *gin.Context) {
 		panic("ERROR")
 	}
 
-	newOrder.Price = fetchQuote(c, newOrder.ID, newOrder.Stock).Price
+	theQuote, err := fetchQuote(c, "SELL", transactionNum, newOrder.ID, newOrder.Stock, maxAge)
+	if err != nil {
+		quoteUnavailable(c, transactionNum, newOrder.ID, newOrder.Stock, err)
+		return
+	}
+	if !quoteVerified(c, transactionNum, newOrder, theQuote) {
+		return
+	}
+	newOrder.Price = theQuote.Price
 	newOrder.Qty = int(math.Floor(newOrder.Amount / newOrder.Price))
 	newOrder.Amount = newOrder.Price * float64(newOrder.Qty) // How much user will be charged based on  int Qty of stocks at surr price
 
@@ -542,6 +720,7 @@ func sellStock(c *gin.Context) {
 }
 
 func commitSell(c *gin.Context) {
+	db := c.MustGet("db").(*mongo.Database)
 	var commitOrder order
 
 	// Calling BindJSON to bind the recieved JSON to new BalDif
@@ -555,7 +734,7 @@ func commitSell(c *gin.Context) {
 	match := false
 	j := 0
 	for _, o := range sells {
-		if o.ID == commitOrder.ID {
+		if o.ID == commitOrder.ID && o.OrderId == commitOrder.OrderId {
 			match = true
 
 			// Logging user command
@@ -563,12 +742,20 @@ func commitSell(c *gin.Context) {
 			logEvent(commitSellCmdLog)
 
 			// change user balance
-			to_match := bson.D{{"user_id", commitOrder.ID}}
+			if !holdsUser(c, "COMMIT_SELL", commitOrder.ID) {
+				transaction_counter += 1
+				return
+			}
 			to_update := bson.D{{"cash_balance", +o.Amount}, {o.Stock, -o.Qty}}
-			r := updateOne("users", to_match, to_update, "$inc")
-
-			if r != "ok" {
-				panic(r)
+			applied, err := applyOrder(db, o, to_update)
+			if err != nil {
+				panic(err)
+			}
+			if !applied {
+				sells = append(sells[:j], sells[j+1:]...)
+				c.IndentedJSON(http.StatusOK, ALREADY_COMMITTED)
+				transaction_counter += 1
+				return
 			}
 
 			// Logging account changes
@@ -585,7 +772,7 @@ func commitSell(c *gin.Context) {
 	}
 
 	// Logging error
-	if !match {
+	if !match && !committedBefore(c, commitOrder) {
 		// Logging user command
 		commitSellCmdLog := logEntry{LogType: USERCOMMAND, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: "COMMIT_SELL", Username: commitOrder.ID}
 		logEvent(commitSellCmdLog)
@@ -593,10 +780,10 @@ func commitSell(c *gin.Context) {
 		// Logging command did not happen due to error
 		errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: "COMMIT_SELL", Username: commitOrder.ID}
 		logEvent(errorLog)
+
+		c.IndentedJSON(http.StatusForbidden, "No previous sell order")
 	}
 	transaction_counter += 1
-
-	c.IndentedJSON(http.StatusForbidden, "No previous sell order")
 }
 
 func cancelSell(c *gin.Context) {
@@ -604,7 +791,7 @@ func cancelSell(c *gin.Context) {
 	match := false
 	j := 0
 	for _, o := range sells {
-		if o.ID == id {
+		if o.ID == id && o.OrderId == "" {
 			match = true
 
 			// Logging user command
@@ -635,6 +822,12 @@ func cancelSell(c *gin.Context) {
 
 }
 
+// Counters for the quote cache
+func metrics(c *gin.Context) {
+	quoteCache := c.MustGet("cache").(*cache.Metered)
+	c.IndentedJSON(http.StatusOK, gin.H{"cache": quoteCache.Metrics()})
+}
+
 func healthcheck(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
@@ -691,8 +884,12 @@ func setAmount(c *gin.Context) {
 }
 
 func cancelSet(c *gin.Context) {
+	pollingService := c.MustGet("pollingService").(string)
+
 	var limitorder LimitOrder
 	limitorder.Type = c.Param("type")
+	limitorder.User = c.Param("id")
+	limitorder.Stock = c.Param("stock")
 
 	var cmd string
 	if limitorder.Type == "buy" {
@@ -702,31 +899,39 @@ func cancelSet(c *gin.Context) {
 	}
 
 	// Logging user command
-	cmdLog := logEntry{LogType: USERCOMMAND, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: cmd, Username: limitorder.User}
+	cmdLog := logEntry{LogType: USERCOMMAND, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: cmd, Username: limitorder.User, StockSymbol: limitorder.Stock}
 	logEvent(cmdLog)
 
-	// Calling BindJSON to bind the recieved JSON
-	if err := c.BindJSON(&limitorder); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, "Bad request")
+	if !holdsUser(c, cmd, limitorder.User) {
+		transaction_counter += 1
 		return
 	}
 
 	match := false
-	j := 0
-	for _, o := range uncommited_limit_orders {
-		if o.User == limitorder.User {
-			if o.Type == limitorder.Type {
-				match = true
-				uncommited_limit_orders = append(uncommited_limit_orders[:j], uncommited_limit_orders[j+1:]...)
-			}
+	for j, o := range uncommited_limit_orders {
+		if o.User == limitorder.User && o.Type == limitorder.Type && o.Stock == limitorder.Stock {
+			match = true
+			uncommited_limit_orders = append(uncommited_limit_orders[:j], uncommited_limit_orders[j+1:]...)
+			break
 		}
-		j++
+	}
+
+	// Triggers that were already set live in the polling service
+	if !match {
+		found, err := cancelActiveOrder(pollingService, limitorder.User, limitorder.Type, limitorder.Stock)
+		if err != nil {
+			log.Printf("cancelling active order: %s\n", err)
+		}
+		match = found
 	}
 
 	if !match {
 		// Logging error event
-		errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: cmd, Username: limitorder.User}
+		errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: cmd, Username: limitorder.User, StockSymbol: limitorder.Stock}
 		logEvent(errorLog)
+		c.IndentedJSON(http.StatusForbidden, "No previous set order")
+	} else {
+		c.IndentedJSON(http.StatusOK, "ok")
 	}
 	transaction_counter += 1
 }
@@ -763,19 +968,19 @@ func setTrigger(c *gin.Context) {
 		if o.User == limitorder.User {
 			if o.Type == limitorder.Type {
 				o.Price = limitorder.Price
-				parsedJson, err := json.Marshal(o)
-				if err != nil {
-					panic(err)
+				if !holdsUser(c, cmd, limitorder.User) {
+					transaction_counter += 1
+					return
 				}
-
-				req, err := http.NewRequest(http.MethodPost, pollingService + "/new_limit", bytes.NewBuffer(parsedJson))
-				if err != nil {
-					panic(err)
-				}
-
-				_, err = http.DefaultClient.Do(req)
-				if err != nil {
-					panic(err)
+				// The order stays uncommitted, so the trigger can be set again,
+				// unless the polling service has stored it
+				if err := armTrigger(pollingService, o); err != nil {
+					log.Printf("arming trigger: %s\n", err)
+					errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: cmd, Username: limitorder.User, Funds: limitorder.Amount, ErrorMessage: err.Error()}
+					logEvent(errorLog)
+					c.IndentedJSON(http.StatusServiceUnavailable, "Trigger could not be set")
+					transaction_counter += 1
+					return
 				}
 
 				uncommited_limit_orders = append(uncommited_limit_orders[:j], uncommited_limit_orders[j+1:]...)
@@ -831,6 +1036,7 @@ func dumplog(c *gin.Context) {
 // status of their accounts as well as any set buy or sell triggers and their parameters
 func displaySummary(c *gin.Context) {
 	db := c.MustGet("db").(*mongo.Database)
+	pollingService := c.MustGet("pollingService").(string)
 
 	// Params: userid
 	id := c.Param("id")
@@ -862,6 +1068,13 @@ func displaySummary(c *gin.Context) {
 		}
 	}
 
+	// ...including the ones already armed in the polling service...
+	activeOrders, err := fetchActiveOrders(pollingService, id)
+	if err != nil {
+		log.Printf("fetching active orders: %s\n", err)
+	}
+	limitOrders = append(limitOrders, activeOrders...)
+
 	// ...is displayed to the user.
 	data := displayCmdData{Transactions: logs, Acc_Status: acc_status, LimitOrders: limitOrders}
 
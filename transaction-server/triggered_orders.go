@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Buys and sells fired by the polling service carry the id it gave the trigger
+// when it was armed. The polling service sends an order again whenever it
+// can't tell whether it went through, so the ids of the orders committed for a
+// user are kept on their document, and an order whose id is there is answered
+// as already committed rather than committed twice. A triggered order's pending
+// buy or sell is only committed by the polling service, never by the user's
+// own COMMIT_BUY or COMMIT_SELL.
+
+// Committed order ids kept per user, enough to cover any order still being
+// retried
+const COMMITTED_ORDERS_KEPT = 100
+
+const ALREADY_COMMITTED = "already committed"
+
+// Whether the order with the given id has been committed for the user
+func orderCommitted(db *mongo.Database, userId string, orderId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := db.Collection("users").CountDocuments(ctx, bson.M{"user_id": userId, "committed_orders": orderId})
+	return n > 0, err
+}
+
+// Applies the change an order makes to its user's account. A triggered order
+// is applied and recorded as committed in the same update, so it is applied at
+// most once; reports false if it already had been.
+func applyOrder(db *mongo.Database, o order, change bson.D) (bool, error) {
+	if o.OrderId == "" {
+		if r := updateOne("users", bson.D{{Key: "user_id", Value: o.ID}}, change, "$inc"); r != "ok" {
+			return false, errors.New(r)
+		}
+		return true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": o.ID, "committed_orders": bson.M{"$ne": o.OrderId}}
+	record := bson.M{"$each": bson.A{o.OrderId}, "$slice": -COMMITTED_ORDERS_KEPT}
+	res, err := db.Collection("users").UpdateOne(ctx, filter, bson.M{"$inc": change, "$push": bson.M{"committed_orders": record}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// Gets a triggered buy or sell ready to be made again, dropping the pending
+// order an earlier attempt left. Returns false, having replied, if the order
+// has already been committed.
+func retryableOrder(c *gin.Context, pending *[]order, o order) bool {
+	if o.OrderId == "" {
+		return true
+	}
+	db := c.MustGet("db").(*mongo.Database)
+
+	committed, err := orderCommitted(db, o.ID, o.OrderId)
+	if err != nil {
+		c.IndentedJSON(http.StatusServiceUnavailable, "Could not check order")
+		return false
+	}
+	if committed {
+		c.IndentedJSON(http.StatusOK, ALREADY_COMMITTED)
+		return false
+	}
+
+	for j, p := range *pending {
+		if p.OrderId == o.OrderId {
+			*pending = append((*pending)[:j], (*pending)[j+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Answers a commit that matched no pending order. A triggered order that was
+// committed by an earlier attempt is reported as such; reports whether it was.
+func committedBefore(c *gin.Context, o order) bool {
+	if o.OrderId == "" {
+		return false
+	}
+	db := c.MustGet("db").(*mongo.Database)
+
+	committed, err := orderCommitted(db, o.ID, o.OrderId)
+	if err != nil {
+		c.IndentedJSON(http.StatusServiceUnavailable, "Could not check order")
+		return true
+	}
+	if committed {
+		c.IndentedJSON(http.StatusOK, ALREADY_COMMITTED)
+	}
+	return committed
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"cache"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Commands that change a user's account are serialized per user across every
+// replica with a lease lock named after the user ID. The lock is held for the
+// whole handler and renewed while it runs. Should Redis go down, the cache
+// falls back to locks held within this process.
+
+// The shortest --user-lock-ttl. The lease is renewed every third of it, so a
+// much shorter one would be lost to a slow round trip to Redis.
+const MIN_USER_LOCK_TTL = time.Second
+
+var user_lock_ttl = 10 * time.Second
+var user_lock_wait = 5 * time.Second
+
+// The user a request is for: the id path parameter, or the id field of its
+// JSON body
+func requestUser(c *gin.Context) (string, error) {
+	if id := c.Param("id"); id != "" {
+		return id, nil
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	// Put the body back for the handler to bind
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var user struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &user); err != nil {
+		return "", err
+	}
+	if user.ID == "" {
+		return "", errors.New("no user id")
+	}
+	return user.ID, nil
+}
+
+// Middleware running the rest of the chain under the user's lock
+func serializeUser(c *gin.Context) {
+	id, err := requestUser(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, "Bad request")
+		return
+	}
+
+	quoteCache := c.MustGet("cache").(cache.Cache)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), user_lock_wait)
+	lease, err := acquireUser(ctx, quoteCache, id)
+	cancel()
+	if err != nil {
+		// Logging lock failure
+		errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Username: id, ErrorMessage: "locking user: " + err.Error()}
+		logEvent(errorLog)
+
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, "User busy, try again")
+		return
+	}
+
+	c.Set("userLease", lease)
+	c.Next()
+
+	if err := lease.Release(context.Background()); err != nil {
+		// Another replica may have run a command for this user at the same time
+		errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Username: id, ErrorMessage: "releasing user lock: " + err.Error()}
+		logEvent(errorLog)
+	}
+}
+
+// Takes the user's lock, retrying until ctx is done so a single failed request
+// to Redis doesn't fail the command
+func acquireUser(ctx context.Context, quoteCache cache.Cache, id string) (*cache.Lease, error) {
+	for {
+		lease, err := quoteCache.Acquire(ctx, "user:"+id, user_lock_ttl)
+		if err == nil || ctx.Err() != nil {
+			return lease, err
+		}
+		log.Printf("locking user %s: %s\n", id, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(cache.MAX_LOCK_RETRY):
+		}
+	}
+}
+
+// Whether the request still holds its user's lock, to be checked before
+// changing the user's state. If the lease was lost another replica may be
+// running a command for the same user, so the request is refused with 409.
+func holdsUser(c *gin.Context, command string, id string) bool {
+	lease, found := c.Get("userLease")
+	if !found {
+		return true
+	}
+
+	select {
+	case <-lease.(*cache.Lease).Lost():
+	default:
+		return true
+	}
+
+	// Logging lost lock
+	errorLog := logEntry{LogType: ERR_EVENT, Timestamp: time.Now().Unix(), Server: "own-server", TransactionNum: transaction_counter, Command: command, Username: id, ErrorMessage: "user lock lost"}
+	logEvent(errorLog)
+
+	c.IndentedJSON(http.StatusConflict, "User busy, try again")
+	return false
+}